@@ -0,0 +1,78 @@
+package tiwatch
+
+import (
+	"sync"
+	"time"
+)
+
+// WatchWithAck is Watch with acknowledgments: the watcher doesn't move past
+// an event until it's acked by calling ack with its Seq, so an event that
+// wasn't processed is delivered again, for at-least-once processing. An
+// event that isn't acked within redeliverAfter is delivered again, as the
+// key is at that time, with the next Seq, and only the ack of the latest
+// delivery counts. A zero redeliverAfter waits for the ack forever.
+//
+// Un-acked events block the watcher: no later change of key is delivered
+// until the pending event is acked. Acks are kept in memory only, a watcher
+// created after a restart starts from the current version of key, like
+// Watch, so whatever wasn't acked before is not redelivered then.
+func (b *TiWatch) WatchWithAck(key string, redeliverAfter time.Duration) (<-chan Op, func(seq uint64)) {
+	ch := make(chan Op)
+	acks := &acker{timeout: redeliverAfter, notify: make(chan struct{}, 1)}
+	go b.watch(key, unseeded, ch, nil, nil, acks)
+	return ch, acks.ack
+}
+
+// acker collects the acks of a watcher, see WatchWithAck.
+type acker struct {
+	timeout time.Duration
+
+	mu sync.Mutex
+	// highest acked Seq
+	acked  uint64
+	notify chan struct{}
+}
+
+func (a *acker) ack(seq uint64) {
+	a.mu.Lock()
+	if seq > a.acked {
+		a.acked = seq
+	}
+	a.mu.Unlock()
+	select {
+	case a.notify <- struct{}{}:
+	default:
+	}
+}
+
+// wait waits for the ack of the event seq. It reports whether the event was
+// acked before the timeout, and ok false if stop or closed was closed first.
+// A nil acker acks every event right away.
+func (a *acker) wait(seq uint64, stop <-chan struct{}, closed <-chan struct{}) (acked bool, ok bool) {
+	if a == nil {
+		return true, true
+	}
+	var timeout <-chan time.Time
+	if a.timeout > 0 {
+		timer := time.NewTimer(a.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	for {
+		a.mu.Lock()
+		acked = a.acked >= seq
+		a.mu.Unlock()
+		if acked {
+			return true, true
+		}
+		select {
+		case <-a.notify:
+		case <-timeout:
+			return false, true
+		case <-stop:
+			return false, false
+		case <-closed:
+			return false, false
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package tiwatch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAckerWait(t *testing.T) {
+	closedCh := make(chan struct{})
+	close(closedCh)
+	for _, tc := range []struct {
+		name      string
+		acker     func() *acker
+		seq       uint64
+		stop      <-chan struct{}
+		closed    <-chan struct{}
+		acked, ok bool
+	}{
+		{"nil acker", func() *acker { return nil }, 1, nil, nil, true, true},
+		{"acked", func() *acker { a := newTestAcker(0); a.ack(1); return a }, 1, nil, nil, true, true},
+		{"later seq acked", func() *acker { a := newTestAcker(0); a.ack(3); return a }, 2, nil, nil, true, true},
+		{"earlier seq acked", func() *acker { a := newTestAcker(time.Millisecond); a.ack(1); return a }, 2, nil, nil, false, true},
+		{"timeout", func() *acker { return newTestAcker(time.Millisecond) }, 1, nil, nil, false, true},
+		{"stopped", func() *acker { return newTestAcker(0) }, 1, closedCh, nil, false, false},
+		{"closed", func() *acker { return newTestAcker(0) }, 1, nil, closedCh, false, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			acked, ok := tc.acker().wait(tc.seq, tc.stop, tc.closed)
+			if acked != tc.acked || ok != tc.ok {
+				t.Fatalf("got acked %v, ok %v, want %v, %v", acked, ok, tc.acked, tc.ok)
+			}
+		})
+	}
+}
+
+// An ack while waiting ends the wait.
+func TestAckerAckWhileWaiting(t *testing.T) {
+	a := newTestAcker(0)
+	go func() {
+		time.Sleep(time.Millisecond)
+		a.ack(1)
+	}()
+	if acked, ok := a.wait(1, nil, nil); !acked || !ok {
+		t.Fatalf("got acked %v, ok %v", acked, ok)
+	}
+}
+
+func newTestAcker(timeout time.Duration) *acker {
+	return &acker{timeout: timeout, notify: make(chan struct{}, 1)}
+}
@@ -0,0 +1,229 @@
+package tiwatch
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/c4pt0r/log"
+)
+
+// aliasNamespace holds the aliases, see SetAlias, a key per logical name
+// whose value is the concrete namespace.
+const aliasNamespace = "_aliases"
+
+// ErrNoAlias is returned by NewAliased for a logical name without an alias.
+var ErrNoAlias = errors.New("tiwatch: alias not found")
+
+// SetAlias points the logical name at the concrete namespace, in the
+// database of dsn, e.g. to switch readers over to a namespace populated in
+// advance, blue/green style. Every AliasWatch of logical follows the switch.
+// Aliases are stored in the namespace "_aliases", which must not be used
+// otherwise.
+func SetAlias(dsn string, logical string, concrete string) error {
+	if err := validate(dsn, concrete); err != nil {
+		return err
+	}
+	meta := New(dsn, aliasNamespace)
+	if err := meta.Init(); err != nil {
+		return err
+	}
+	defer meta.Close()
+	return meta.Set(logical, concrete)
+}
+
+// AliasWatch is a TiWatch for the namespace a logical name is aliased to, see
+// SetAlias, that follows the alias when it's switched to another namespace.
+type AliasWatch struct {
+	dsn     string
+	logical string
+	opts    []Option
+	meta    *TiWatch
+
+	mu sync.Mutex
+	tw *TiWatch
+	// closed when tw is replaced or a is closed
+	flipped chan struct{}
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// NewAliased resolves the alias logical in the database of dsn and connects
+// to the namespace it points to, with opts. It fails with ErrNoAlias if
+// logical has no alias.
+func NewAliased(dsn string, logical string, opts ...Option) (*AliasWatch, error) {
+	meta := New(dsn, aliasNamespace)
+	if err := meta.Init(); err != nil {
+		return nil, err
+	}
+	concrete, version, exists, err := meta.GetWithVersion(logical)
+	if err != nil || !exists {
+		meta.Close()
+		if err == nil {
+			err = ErrNoAlias
+		}
+		return nil, err
+	}
+	tw := New(dsn, concrete, opts...)
+	if err := tw.Init(); err != nil {
+		meta.Close()
+		return nil, err
+	}
+	a := &AliasWatch{
+		dsn:     dsn,
+		logical: logical,
+		opts:    opts,
+		meta:    meta,
+		tw:      tw,
+		flipped: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+	ch := make(chan Op)
+	go meta.watch(logical, version, ch, a.closed, nil, nil)
+	go a.follow(ch)
+	return a, nil
+}
+
+// follow switches a over whenever the alias changes.
+func (a *AliasWatch) follow(ch <-chan Op) {
+	for op := range ch {
+		if op.Type == TypeDelete {
+			log.Warnf("alias %s deleted, staying on %s", a.logical, a.Namespace())
+			continue
+		}
+		if op.Val == a.Namespace() {
+			continue
+		}
+		tw := New(a.dsn, op.Val, a.opts...)
+		if err := tw.Init(); err != nil {
+			log.Errorf("alias %s: can't switch to %s, staying on %s: %v", a.logical, op.Val, a.Namespace(), err)
+			continue
+		}
+		a.mu.Lock()
+		select {
+		case <-a.closed:
+			a.mu.Unlock()
+			tw.Close()
+			return
+		default:
+		}
+		old := a.tw
+		a.tw = tw
+		close(a.flipped)
+		a.flipped = make(chan struct{})
+		a.mu.Unlock()
+		log.Infof("alias %s: switched from %s to %s", a.logical, old.ns, tw.ns)
+		old.Close()
+	}
+}
+
+func (a *AliasWatch) current() (*TiWatch, <-chan struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.tw, a.flipped
+}
+
+// Namespace returns the namespace the alias currently points to.
+func (a *AliasWatch) Namespace() string {
+	tw, _ := a.current()
+	return tw.ns
+}
+
+// Current returns the TiWatch of the namespace the alias currently points
+// to, for the calls AliasWatch doesn't wrap. It's closed when the alias
+// switches.
+func (a *AliasWatch) Current() *TiWatch {
+	tw, _ := a.current()
+	return tw
+}
+
+func (a *AliasWatch) Get(key string) (string, bool, error) {
+	return a.Current().Get(key)
+}
+
+func (a *AliasWatch) GetWithVersion(key string) (string, int64, bool, error) {
+	return a.Current().GetWithVersion(key)
+}
+
+func (a *AliasWatch) Set(key string, value string) error {
+	return a.Current().Set(key, value)
+}
+
+func (a *AliasWatch) Delete(key string) error {
+	return a.Current().Delete(key)
+}
+
+// Watch watches key in the namespace the alias points to. When the alias
+// switches, the watcher moves to the new namespace and first delivers the
+// state of key there, a TypeUpdate with its value or a TypeDelete if it
+// doesn't exist, as a resync, then its changes. Seq keeps counting across
+// switches. The channel is closed by Close.
+func (a *AliasWatch) Watch(key string) <-chan Op {
+	out := make(chan Op)
+	go func() {
+		defer close(out)
+		var seq uint64
+		resync := false
+		for {
+			tw, flipped := a.current()
+			value, version, exists, err := tw.GetWithVersion(key)
+			if err != nil {
+				log.Error(err)
+				if !sleep(PollDuration, a.closed) {
+					return
+				}
+				continue
+			}
+			if resync {
+				op := Op{Type: TypeUpdate, Key: key, Val: value}
+				if !exists {
+					op.Type = TypeDelete
+				}
+				seq++
+				op.Seq = seq
+				select {
+				case out <- op:
+				case <-a.closed:
+					return
+				}
+			}
+			ch := make(chan Op)
+			go tw.watch(key, version, ch, flipped, nil, nil)
+			for op := range ch {
+				seq++
+				op.Seq = seq
+				select {
+				case out <- op:
+				case <-a.closed:
+					// the watcher stops on flipped, closed by Close
+					return
+				}
+			}
+			select {
+			case <-a.closed:
+				return
+			default:
+			}
+			resync = true
+		}
+	}()
+	return out
+}
+
+// Close stops following the alias and closes the watchers of a and its
+// connections.
+func (a *AliasWatch) Close() error {
+	a.closeOnce.Do(func() {
+		close(a.closed)
+	})
+	a.mu.Lock()
+	select {
+	case <-a.flipped:
+	default:
+		close(a.flipped)
+	}
+	tw := a.tw
+	a.mu.Unlock()
+	a.meta.Close()
+	return tw.Close()
+}
@@ -0,0 +1,81 @@
+package tiwatch
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long to wait before retrying a failed operation. Every
+// retrying path of a TiWatch, e.g. every watcher, gets a Backoff of its own,
+// see WithBackoff, and uses it from a single goroutine, so implementations
+// may keep state between attempts.
+type Backoff interface {
+	// Next returns the wait before retry number attempt, starting at 1.
+	Next(attempt int) time.Duration
+	// Reset is called when the retrying path succeeded again.
+	Reset()
+}
+
+// WithBackoff sets how the retrying paths of b back off: each one calls
+// newBackoff for a Backoff of its own. By default they use an
+// ExponentialBackoff from 1 second up to 30 seconds.
+func WithBackoff(newBackoff func() Backoff) Option {
+	return func(b *TiWatch) {
+		b.newBackoff = newBackoff
+	}
+}
+
+func defaultBackoff() Backoff {
+	return &ExponentialBackoff{Base: time.Second, Max: 30 * time.Second}
+}
+
+// ExponentialBackoff doubles the wait with every attempt, from Base up to Max,
+// then picks a random wait between half of it and all of it, so that clients
+// failing together don't retry together. It never waits less than Base, nor
+// less than a millisecond.
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// minBackoff keeps a zero Base from retrying in a busy loop.
+const minBackoff = time.Millisecond
+
+func (e *ExponentialBackoff) Next(attempt int) time.Duration {
+	d := e.Base
+	// doubling past Max/2 could overflow
+	for i := 1; i < attempt && d < e.Max; i++ {
+		if d > e.Max/2 {
+			d = e.Max
+			break
+		}
+		d *= 2
+	}
+	if d > e.Max {
+		d = e.Max
+	}
+	if d > 1 {
+		d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+	floor := e.Base
+	if floor < minBackoff {
+		floor = minBackoff
+	}
+	if d < floor {
+		d = floor
+	}
+	return d
+}
+
+func (e *ExponentialBackoff) Reset() {}
+
+// ConstantBackoff always waits Interval.
+type ConstantBackoff struct {
+	Interval time.Duration
+}
+
+func (c *ConstantBackoff) Next(attempt int) time.Duration {
+	return c.Interval
+}
+
+func (c *ConstantBackoff) Reset() {}
@@ -0,0 +1,45 @@
+package tiwatch
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		b        ExponentialBackoff
+		attempt  int
+		min, max time.Duration
+	}{
+		{"first", ExponentialBackoff{Base: time.Second, Max: 30 * time.Second}, 1, 500 * time.Millisecond, time.Second},
+		{"before first", ExponentialBackoff{Base: time.Second, Max: 30 * time.Second}, 0, 500 * time.Millisecond, time.Second},
+		{"doubled", ExponentialBackoff{Base: time.Second, Max: 30 * time.Second}, 3, 2 * time.Second, 4 * time.Second},
+		{"capped", ExponentialBackoff{Base: time.Second, Max: 30 * time.Second}, 6, 15 * time.Second, 30 * time.Second},
+		{"many attempts", ExponentialBackoff{Base: time.Second, Max: 30 * time.Second}, 1000, 15 * time.Second, 30 * time.Second},
+		{"near overflow", ExponentialBackoff{Base: time.Second, Max: 1<<63 - 1}, 40, 1 << 61, 1<<63 - 1},
+		{"overflowing shift", ExponentialBackoff{Base: 5 * time.Second, Max: 1<<63 - 1}, 32, 1 << 62, 1<<63 - 1},
+		{"zero base", ExponentialBackoff{Max: 30 * time.Second}, 1, minBackoff, minBackoff},
+		{"zero base, later attempt", ExponentialBackoff{Max: 30 * time.Second}, 10, minBackoff, minBackoff},
+		{"tiny base", ExponentialBackoff{Base: time.Nanosecond, Max: 30 * time.Second}, 3, minBackoff, minBackoff},
+		{"zero max", ExponentialBackoff{Base: time.Second}, 5, time.Second, time.Second},
+		{"base over max", ExponentialBackoff{Base: time.Minute, Max: time.Second}, 1, time.Minute, time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if d := tc.b.Next(tc.attempt); d < tc.min || d > tc.max {
+					t.Fatalf("attempt %d: %s, want %s to %s", tc.attempt, d, tc.min, tc.max)
+				}
+			}
+		})
+	}
+}
+
+func TestConstantBackoff(t *testing.T) {
+	b := &ConstantBackoff{Interval: time.Second}
+	for _, attempt := range []int{0, 1, 2, 100} {
+		if d := b.Next(attempt); d != time.Second {
+			t.Fatalf("attempt %d: %s", attempt, d)
+		}
+	}
+}
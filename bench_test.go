@@ -0,0 +1,92 @@
+package tiwatch
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchKeys are the key counts the benchmarks run with: the parallel
+// writers of a benchmark spread over that many keys, so fewer keys mean more
+// contention. Pick one with -bench, e.g. -bench 'Set/keys=1$'.
+var benchKeys = []int{1, 16, 1024}
+
+func benchKey(i int) string {
+	return fmt.Sprintf("bench/%d", i)
+}
+
+// BenchmarkSet measures the write throughput of Set from parallel writers.
+func BenchmarkSet(b *testing.B) {
+	for _, keys := range benchKeys {
+		b.Run(fmt.Sprintf("keys=%d", keys), func(b *testing.B) {
+			tw := newTestWatch(b)
+			var n int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&n, 1)
+					if err := tw.Set(benchKey(int(i)%keys), "v"); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkWatchLatency measures the time from a Set to the event of a
+// watcher of the key, with a 10ms PollDuration.
+func BenchmarkWatchLatency(b *testing.B) {
+	pollDuration := PollDuration
+	PollDuration = 10 * time.Millisecond
+	defer func() { PollDuration = pollDuration }()
+
+	tw := newTestWatch(b)
+	if err := tw.Set("bench", "init"); err != nil {
+		b.Fatal(err)
+	}
+	ch := tw.Watch("bench")
+	var total time.Duration
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		if err := tw.Set("bench", fmt.Sprint(i)); err != nil {
+			b.Fatal(err)
+		}
+		select {
+		case <-ch:
+		case <-time.After(10 * time.Second):
+			b.Fatal("no event")
+		}
+		total += time.Since(start)
+	}
+	b.ReportMetric(float64(total.Microseconds())/float64(b.N), "µs/event")
+}
+
+// BenchmarkPoll measures the QPS of the version polls of watchers, spread
+// over the keys.
+func BenchmarkPoll(b *testing.B) {
+	for _, keys := range benchKeys {
+		b.Run(fmt.Sprintf("keys=%d", keys), func(b *testing.B) {
+			tw := newTestWatch(b)
+			for i := 0; i < keys; i++ {
+				if err := tw.Set(benchKey(i), "v"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			var n int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					i := atomic.AddInt64(&n, 1)
+					if _, err := tw.getMaxVersion(benchKey(int(i) % keys)); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
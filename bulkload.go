@@ -0,0 +1,116 @@
+package tiwatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// WithBulkLoadBatchSize sets how many rows BulkLoad writes per INSERT
+// statement, 1000 by default.
+func WithBulkLoadBatchSize(n int) Option {
+	return func(b *TiWatch) {
+		if n > 0 {
+			b.bulkLoadBatchSize = n
+		}
+	}
+}
+
+// BulkLoadOption configures a single BulkLoad call.
+type BulkLoadOption func(*bulkLoad)
+
+type bulkLoad struct {
+	insertOnly bool
+}
+
+// BulkLoadInsertOnly makes a BulkLoad insert only keys that don't exist yet,
+// and fail with ErrKeyExists on a batch with an existing key, instead of
+// overwriting it. The batches before it are already committed then. Existing
+// keys are never changed behind the back of their watchers or of a
+// SetIfVersion based on an earlier read. Watchers of the loaded keys still
+// see them created, at version 1 like any new key: BulkLoad has no way to
+// keep its writes from watchers.
+func BulkLoadInsertOnly() BulkLoadOption {
+	return func(l *bulkLoad) {
+		l.insertOnly = true
+	}
+}
+
+// ErrKeyExists is returned by BulkLoad with BulkLoadInsertOnly when a loaded key
+// already exists.
+var ErrKeyExists = errors.New("tiwatch: key exists")
+
+// BulkLoad writes all key/value pairs yielded by pairs with batched multi-row
+// INSERT statements, without the per-key FOR UPDATE transaction of Set. pairs
+// has the shape of an iter.Seq2[string, string], so one can be passed as is.
+//
+// It is a throughput path for imports into an empty namespace, or one that
+// nobody else accesses during the load: it isn't safe under concurrent
+// writes, batches are committed one by one so a failed load is partially
+// applied. Every loaded key gets the next version, as with Set, so its
+// watchers see the load, see BulkLoadInsertOnly to leave existing keys
+// alone. In history mode the loaded keys must not exist yet.
+func (b *TiWatch) BulkLoad(pairs func(yield func(key, value string) bool), opts ...BulkLoadOption) error {
+	var l bulkLoad
+	for _, opt := range opts {
+		opt(&l)
+	}
+	var (
+		args []interface{}
+		err  error
+	)
+	pairs(func(key, value string) bool {
+		if b.jsonValues && !json.Valid([]byte(value)) {
+			err = fmt.Errorf("%w: key %q", ErrInvalidJSON, key)
+			return false
+		}
+		stored := b.encode(value)
+		b.checkSize(key, stored)
+		args = append(args, key, stored)
+		if len(args)/2 < b.bulkLoadBatchSize {
+			return true
+		}
+		err = b.bulkInsert(args, l.insertOnly)
+		args = args[:0]
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(args) > 0 {
+		return b.bulkInsert(args, l.insertOnly)
+	}
+	return nil
+}
+
+// bulkInsert inserts the flattened key/value pairs in args as new rows,
+// only new ones if insertOnly.
+func (b *TiWatch) bulkInsert(args []interface{}, insertOnly bool) error {
+	defer b.observe("bulkload", "", time.Now())
+	values := strings.TrimSuffix(strings.Repeat("(?, ?, 1), ", len(args)/2), ", ")
+	stmt := b.tag("bulkload") + fmt.Sprintf(`
+		INSERT INTO
+			%s (k, v, version)
+		VALUES %s
+	`, genTableName(b.ns), values)
+	if !b.history && !insertOnly {
+		stmt += `
+		ON DUPLICATE KEY UPDATE
+			v = VALUES(v),
+			version = version + 1
+		`
+	}
+	_, err := b.db.Exec(stmt, args...)
+	var merr *mysql.MySQLError
+	if insertOnly && errors.As(err, &merr) && merr.Number == errDupEntry {
+		return fmt.Errorf("%w: %s", ErrKeyExists, merr.Message)
+	}
+	return err
+}
+
+// errDupEntry is the MySQL error of a duplicate primary key.
+const errDupEntry = 1062
@@ -0,0 +1,75 @@
+package tiwatch
+
+import (
+	"sync"
+	"time"
+)
+
+// CoalesceGroups turns the per key events of watchers, e.g. the channels of
+// WatchMany, into per group notifications, for resources made of several
+// keys, like obj/123/name and obj/123/addr. group maps a key to the ID of its
+// group. Events are collected for interval from the first one after the last
+// delivery, then every group that changed meanwhile is delivered once, as an
+// Op whose Key is the group ID, with the Type of the latest event of the
+// group and no Val: a notification identifies the group, not what changed,
+// so the consumer has to read the keys of the group again. Seq numbers the
+// notifications from 1 on. The returned channel is closed after all of chs
+// are closed and the pending notifications are delivered.
+func CoalesceGroups(chs map[string]<-chan Op, group func(key string) string, interval time.Duration) <-chan Op {
+	in := make(chan Op)
+	var wg sync.WaitGroup
+	wg.Add(len(chs))
+	for _, ch := range chs {
+		go func(ch <-chan Op) {
+			defer wg.Done()
+			for op := range ch {
+				in <- op
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		close(in)
+	}()
+
+	out := make(chan Op)
+	go func() {
+		defer close(out)
+		var (
+			// pending notifications, by group in order of arrival
+			pending = make(map[string]OpType)
+			order   []string
+			flush   <-chan time.Time
+			seq     uint64
+		)
+		deliver := func() {
+			for _, id := range order {
+				seq++
+				out <- Op{Type: pending[id], Key: id, Seq: seq}
+				delete(pending, id)
+			}
+			order = order[:0]
+			flush = nil
+		}
+		for {
+			select {
+			case op, ok := <-in:
+				if !ok {
+					deliver()
+					return
+				}
+				id := group(op.Key)
+				if _, ok := pending[id]; !ok {
+					order = append(order, id)
+				}
+				pending[id] = op.Type
+				if flush == nil {
+					flush = time.After(interval)
+				}
+			case <-flush:
+				deliver()
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,71 @@
+package tiwatch
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func objectGroup(key string) string {
+	return key[:strings.LastIndex(key, "/")]
+}
+
+func TestCoalesceGroups(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ops  []Op
+		want []Op
+	}{
+		{"none", nil, nil},
+		{
+			name: "groups",
+			ops: []Op{
+				{Type: TypeUpdate, Key: "obj/1/name", Val: "a", Seq: 1},
+				{Type: TypeUpdate, Key: "obj/2/name", Val: "b", Seq: 1},
+				{Type: TypeUpdate, Key: "obj/1/addr", Val: "c", Seq: 1},
+				{Type: TypeDelete, Key: "obj/1/name", Seq: 2},
+			},
+			want: []Op{
+				{Type: TypeDelete, Key: "obj/1", Seq: 1},
+				{Type: TypeUpdate, Key: "obj/2", Seq: 2},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := make(chan Op)
+			// pending notifications are delivered once ch is closed, long
+			// before the interval is over
+			out := CoalesceGroups(map[string]<-chan Op{"w": ch}, objectGroup, time.Hour)
+			for _, op := range tc.ops {
+				ch <- op
+			}
+			close(ch)
+			var got []Op
+			for op := range out {
+				got = append(got, op)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCoalesceGroupsInterval(t *testing.T) {
+	a, b := make(chan Op), make(chan Op)
+	out := CoalesceGroups(map[string]<-chan Op{"a": a, "b": b}, objectGroup, 10*time.Millisecond)
+	a <- Op{Type: TypeUpdate, Key: "obj/1/name", Seq: 1}
+	if op := receive(t, out); op != (Op{Type: TypeUpdate, Key: "obj/1", Seq: 1}) {
+		t.Fatalf("got %+v", op)
+	}
+	b <- Op{Type: TypeDelete, Key: "obj/1/addr", Seq: 2}
+	if op := receive(t, out); op != (Op{Type: TypeDelete, Key: "obj/1", Seq: 2}) {
+		t.Fatalf("got %+v", op)
+	}
+	close(a)
+	close(b)
+	if op, ok := <-out; ok {
+		t.Fatalf("got %+v after close", op)
+	}
+}
@@ -0,0 +1,39 @@
+package tiwatch
+
+// Codec converts values between how they are used and how they are stored,
+// e.g. to read and write a legacy table whose values are base64 encoded.
+// Encode must be deterministic, values are compared in their encoded form,
+// and its output must be valid text for the value column.
+type Codec interface {
+	Encode(value []byte) []byte
+	Decode(stored []byte) ([]byte, error)
+}
+
+// WithCodec encodes values with c on every write and decodes them on every
+// read, including the values delivered to watchers. Without it values are
+// stored as is.
+// c is the layer closest to the table: any other value transformation is
+// applied to a value before c encodes it, and after c decodes it.
+func WithCodec(c Codec) Option {
+	return func(b *TiWatch) {
+		b.codec = c
+	}
+}
+
+func (b *TiWatch) encode(value string) string {
+	if b.codec == nil {
+		return value
+	}
+	return string(b.codec.Encode([]byte(value)))
+}
+
+func (b *TiWatch) decode(stored string) (string, error) {
+	if b.codec == nil {
+		return stored, nil
+	}
+	value, err := b.codec.Decode([]byte(stored))
+	if err != nil {
+		return "", err
+	}
+	return string(value), nil
+}
@@ -0,0 +1,122 @@
+package tiwatch
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// State is the connectivity of a TiWatch to TiDB.
+type State int
+
+const (
+	// StateConnected means TiDB is reachable and watchers poll fine.
+	StateConnected State = iota
+	// StateDegraded means TiDB answers pings, but some watchers keep
+	// failing their queries and are retrying.
+	StateDegraded
+	// StateDisconnected means TiDB can't be reached.
+	StateDisconnected
+)
+
+// pingTimeout bounds the pings of the connection state monitor.
+const pingTimeout = 30 * time.Second
+
+// stateDebounce is the number of consecutive checks a new state has to hold
+// for before it's reported, so brief blips don't cause transitions.
+const stateDebounce = 3
+
+func (s State) String() string {
+	switch s {
+	case StateConnected:
+		return "connected"
+	case StateDegraded:
+		return "degraded"
+	case StateDisconnected:
+		return "disconnected"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// ConnectionState returns a channel receiving the current connectivity state
+// of b, then every transition. The state is checked every PollDuration by
+// pinging TiDB and looking at failing watchers, see Degraded; a new state is
+// only reported once it held for a few consecutive checks. A consumer that
+// falls behind only gets the latest state. The channel is closed by Close.
+func (b *TiWatch) ConnectionState() <-chan State {
+	b.stateOnce.Do(func() {
+		b.state = b.probe()
+		go b.monitorState()
+	})
+	ch := make(chan State, 1)
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	ch <- b.state
+	if b.stateDone {
+		close(ch)
+		return ch
+	}
+	b.stateSubs = append(b.stateSubs, ch)
+	return ch
+}
+
+// probe checks the current connectivity state.
+func (b *TiWatch) probe() State {
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	if err := b.db.PingContext(ctx); err != nil {
+		return StateDisconnected
+	}
+	if b.Degraded() {
+		return StateDegraded
+	}
+	return StateConnected
+}
+
+func (b *TiWatch) monitorState() {
+	var (
+		pending State
+		held    int
+	)
+	for sleep(PollDuration, b.closed) {
+		s := b.probe()
+		b.stateMu.Lock()
+		current := b.state
+		b.stateMu.Unlock()
+		switch {
+		case s == current:
+			held = 0
+			continue
+		case s == pending:
+			held++
+		default:
+			pending, held = s, 1
+		}
+		if held >= stateDebounce {
+			b.setState(s)
+			held = 0
+		}
+	}
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	for _, ch := range b.stateSubs {
+		close(ch)
+	}
+	b.stateSubs = nil
+	b.stateDone = true
+}
+
+// setState records s and sends it to every subscriber, replacing a state
+// it didn't receive yet.
+func (b *TiWatch) setState(s State) {
+	b.stateMu.Lock()
+	defer b.stateMu.Unlock()
+	b.state = s
+	for _, ch := range b.stateSubs {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- s
+	}
+}
@@ -0,0 +1,110 @@
+package tiwatch
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrWindowsDisabled is returned by IncrWindowed without
+	// WithWindowedCounters.
+	ErrWindowsDisabled = errors.New("tiwatch: windowed counters disabled")
+	// ErrNotCounter is returned by IncrWindowed for a key whose value isn't
+	// an integer.
+	ErrNotCounter = errors.New("tiwatch: value is not a counter")
+)
+
+// WithWindowedCounters enables IncrWindowed, it adds a window_start column
+// to the table, see Init about schema options.
+func WithWindowedCounters() Option {
+	return func(b *TiWatch) {
+		b.windowedCounters = true
+	}
+}
+
+// IncrWindowed atomically adds delta to the counter stored at key and returns
+// its count within the current window, e.g. for rate limiting across a fleet.
+//
+// Windows are fixed, not sliding: time is cut into consecutive windows of
+// length window, aligned to the Unix epoch, by the clock of TiDB, so all
+// clients agree on the boundaries whatever their own clocks say. The first
+// increment in a new window resets the count to delta. The reset happens
+// lazily, on that increment, so Get and watchers of key keep seeing the count
+// of the last window a key was incremented in; IncrWindowed(key, 0, window)
+// returns the count of the current window. The value of key is the count in
+// decimal, a key that holds anything else fails with ErrNotCounter.
+func (b *TiWatch) IncrWindowed(key string, delta int64, window time.Duration) (int64, error) {
+	defer b.observe("incr", key, time.Now())
+	if !b.windowedCounters {
+		return 0, ErrWindowsDisabled
+	}
+	windowMillis := window.Milliseconds()
+	if windowMillis <= 0 {
+		return 0, fmt.Errorf("tiwatch: invalid window %s: must be at least 1ms", window)
+	}
+	txn, err := b.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback()
+
+	var current int64
+	err = txn.QueryRow(b.tag("incr")+`
+		SELECT CAST(FLOOR(UNIX_TIMESTAMP(NOW(3)) * 1000 / ?) * ? AS SIGNED)
+	`, windowMillis, windowMillis).Scan(&current)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		value   string
+		version int64
+		start   sql.NullInt64
+		count   int64
+	)
+	err = txn.QueryRow(b.tag("lock")+fmt.Sprintf(`
+		SELECT
+			v, version, window_start
+		FROM
+			%s
+		WHERE k = ?
+		ORDER BY version DESC
+		LIMIT 1
+		FOR UPDATE
+	`, genTableName(b.ns)), key).Scan(&value, &version, &start)
+	switch {
+	case err == sql.ErrNoRows:
+	case err != nil:
+		return 0, err
+	default:
+		if value, err = b.decode(value); err != nil {
+			return 0, err
+		}
+		if count, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return 0, ErrNotCounter
+		}
+		if !start.Valid || start.Int64 != current {
+			// first increment of the window
+			count = 0
+		}
+	}
+	count += delta
+
+	if err := b.write(txn, key, strconv.FormatInt(count, 10), version); err != nil {
+		return 0, err
+	}
+	// write stored version+1, whether key existed or not
+	_, err = txn.Exec(b.tag("incr")+fmt.Sprintf(`
+		UPDATE
+			%s
+		SET window_start = ?
+		WHERE k = ? AND version = ?
+	`, genTableName(b.ns)), current, key, version+1)
+	if err != nil {
+		return 0, err
+	}
+	return count, txn.Commit()
+}
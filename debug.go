@@ -0,0 +1,92 @@
+package tiwatch
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of a watch loop, see WatcherState.
+const (
+	statusPolling     = "polling"
+	statusDelivering  = "delivering"
+	statusAwaitingAck = "awaiting ack"
+	statusSleeping    = "sleeping"
+	statusBackingOff  = "backing off"
+)
+
+// WatcherState is a snapshot of a running watcher, see WatcherStates. It
+// holds no values, only keys and versions, so it's safe to expose.
+type WatcherState struct {
+	Key string
+	// Version is the last version the watcher delivered and moved past.
+	Version int64
+	// Interval is the current wait between polls, see CurrentPollInterval.
+	Interval time.Duration
+	LastPoll time.Time
+	// LastErr is the last failed query of the watcher, it isn't reset when
+	// the watcher recovers.
+	LastErr string
+	// Buffered is the number of events waiting in the channel of the
+	// watcher, out of its capacity.
+	Buffered int
+	Capacity int
+	// Status is what the watch loop is doing: "polling", "sleeping" between
+	// polls, "backing off" after a failed query, "delivering" an event, i.e.
+	// waiting for the consumer to receive it, or "awaiting ack", see
+	// WatchWithAck. A watcher that stays "delivering" has a stuck consumer.
+	Status string
+}
+
+// WatcherStates returns the state of every running watcher of b, ordered by
+// key. It's safe to call at any time, it only briefly locks the watchers.
+func (b *TiWatch) WatcherStates() []WatcherState {
+	b.watchersMu.Lock()
+	states := make([]WatcherState, 0, len(b.watchers))
+	for w := range b.watchers {
+		w.mu.Lock()
+		st := WatcherState{
+			Key:      w.key,
+			Version:  w.version,
+			Interval: w.interval,
+			LastPoll: w.lastPoll,
+			Buffered: len(w.ch),
+			Capacity: cap(w.ch),
+			Status:   w.status,
+		}
+		if w.lastErr != nil {
+			st.LastErr = w.lastErr.Error()
+		}
+		w.mu.Unlock()
+		states = append(states, st)
+	}
+	b.watchersMu.Unlock()
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Key < states[j].Key
+	})
+	return states
+}
+
+// DebugDump returns a human readable report of Metrics and WatcherStates,
+// one watcher per line, e.g. for a debug HTTP endpoint.
+func (b *TiWatch) DebugDump() string {
+	var sb strings.Builder
+	m := b.Metrics()
+	fmt.Fprintf(&sb, "namespace %s: %d watchers, %d degraded\n", b.ns, m.Watchers, atomic.LoadInt64(&b.degraded))
+	fmt.Fprintf(&sb, "polls %d, events %d, errors %d\n", m.Polls, m.Events, m.Errors)
+	for _, st := range b.WatcherStates() {
+		lastPoll := "never"
+		if !st.LastPoll.IsZero() {
+			lastPoll = time.Since(st.LastPoll).Round(time.Millisecond).String() + " ago"
+		}
+		fmt.Fprintf(&sb, "watch %q: version %d, %s, interval %s, last poll %s, buffered %d/%d",
+			st.Key, st.Version, st.Status, st.Interval, lastPoll, st.Buffered, st.Capacity)
+		if st.LastErr != "" {
+			fmt.Fprintf(&sb, ", last error: %s", st.LastErr)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,35 @@
+package tiwatch
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Diff merges both sides in byte order, so keys that a collation orders
+// differently from Go, mixed case and non-ASCII, only differ by value.
+func TestDiffKeyOrder(t *testing.T) {
+	a, b := newTestWatch(t), newTestWatch(t, WithHistory())
+	keys := []string{"a", "B", "_x", "é", "Z", "b"}
+	for _, key := range keys {
+		for _, w := range []*TiWatch{a, b} {
+			if err := w.Set("p/"+key, "v"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := b.Set("p/Z", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Set("p/only-a", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := Diff(a.Sub("p").KVSource(), b.Sub("p").KVSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DiffResult{Removed: []string{"only-a"}, Changed: []string{"Z"}}
+	if !reflect.DeepEqual(res, want) {
+		t.Fatalf("got %+v, want %+v", res, want)
+	}
+}
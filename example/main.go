@@ -42,7 +42,7 @@ func main() {
 	for {
 		select {
 		case m := <-ch:
-			log.Infof("RECV: %s", m)
+			log.Infof("RECV: %+v", m)
 		}
 	}
 
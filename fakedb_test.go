@@ -0,0 +1,216 @@
+package tiwatch
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is an in-memory stand-in for a namespace table, behind the
+// "tiwatchfake" driver. It answers the single key reads of watchers, which is
+// enough to test the watch loop without a TiDB, and fails every statement
+// while err is set.
+type fakeStore struct {
+	mu   sync.Mutex
+	rows map[string]fakeRow
+	err  error
+	// afterPoll runs once, right after the next MAX(version) poll, on the
+	// locked rows, to change them between two reads of a watcher
+	afterPoll func(rows map[string]fakeRow)
+}
+
+type fakeRow struct {
+	v       string
+	version int64
+}
+
+func (s *fakeStore) set(key string, v string, version int64) {
+	s.mu.Lock()
+	s.rows[key] = fakeRow{v: v, version: version}
+	s.mu.Unlock()
+}
+
+func (s *fakeStore) delete(key string) {
+	s.mu.Lock()
+	delete(s.rows, key)
+	s.mu.Unlock()
+}
+
+func (s *fakeStore) fail(err error) {
+	s.mu.Lock()
+	s.err = err
+	s.mu.Unlock()
+}
+
+// stores of the open fake databases, by data source name
+var fakeStores sync.Map
+
+func init() {
+	sql.Register("tiwatchfake", fakeDriver{})
+}
+
+// newFakeWatch returns a TiWatch reading from a fresh fakeStore, with fast
+// polls and retries for the duration of the test.
+func newFakeWatch(t *testing.T) (*TiWatch, *fakeStore) {
+	t.Helper()
+	pollDuration := PollDuration
+	PollDuration = 5 * time.Millisecond
+	t.Cleanup(func() { PollDuration = pollDuration })
+
+	s := &fakeStore{rows: make(map[string]fakeRow)}
+	fakeStores.Store(t.Name(), s)
+	t.Cleanup(func() { fakeStores.Delete(t.Name()) })
+	b := New("root@tcp(127.0.0.1:4000)/test", "fake", WithBackoff(func() Backoff { return &ConstantBackoff{Interval: 5 * time.Millisecond} }))
+	db, err := sql.Open("tiwatchfake", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.db = db
+	t.Cleanup(func() {
+		b.Close()
+		// watchers read PollDuration until they're gone
+		eventually(t, "stopped watchers", func() bool { return b.TrackedVersions() == 0 })
+	})
+	return b, s
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	s, ok := fakeStores.Load(name)
+	if !ok {
+		return nil, errors.New("fake: unknown store " + name)
+	}
+	return &fakeConn{s: s.(*fakeStore)}, nil
+}
+
+type fakeConn struct {
+	s *fakeStore
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{s: c.s, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("fake: transactions not supported")
+}
+
+type fakeStmt struct {
+	s     *fakeStore
+	query string
+}
+
+func (st *fakeStmt) Close() error {
+	return nil
+}
+
+func (st *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (st *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("fake: writes not supported")
+}
+
+// Query answers "SELECT <columns> FROM ... WHERE k = ?" for the columns v
+// and version, and the MAX(version) poll of watchers.
+func (st *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	st.s.mu.Lock()
+	defer st.s.mu.Unlock()
+	if st.s.err != nil {
+		return nil, st.s.err
+	}
+	if len(args) != 1 {
+		return nil, errors.New("fake: unsupported query " + st.query)
+	}
+	row, exists := st.s.rows[args[0].(string)]
+	if strings.Contains(st.query, "MAX(version)") {
+		if fn := st.s.afterPoll; fn != nil {
+			st.s.afterPoll = nil
+			defer fn(st.s.rows)
+		}
+		return &fakeRows{cols: []string{"version"}, vals: [][]driver.Value{{row.version}}}, nil
+	}
+	q := st.query[strings.Index(st.query, "SELECT")+len("SELECT"):]
+	q = q[:strings.Index(q, "FROM")]
+	var (
+		cols []string
+		vals []driver.Value
+	)
+	for _, col := range strings.Split(q, ",") {
+		col = strings.TrimSpace(col)
+		cols = append(cols, col)
+		switch col {
+		case "v":
+			vals = append(vals, row.v)
+		case "version":
+			vals = append(vals, row.version)
+		default:
+			return nil, errors.New("fake: unsupported column " + col)
+		}
+	}
+	rows := &fakeRows{cols: cols}
+	if exists {
+		rows.vals = [][]driver.Value{vals}
+	}
+	return rows, nil
+}
+
+type fakeRows struct {
+	cols []string
+	vals [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.cols
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.vals) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.vals[0])
+	r.vals = r.vals[1:]
+	return nil
+}
+
+// receive returns the next Op of ch, failing the test if none comes in time.
+func receive(t *testing.T, ch <-chan Op) Op {
+	t.Helper()
+	select {
+	case op, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return op
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return Op{}
+}
+
+// eventually fails the test if cond doesn't hold within a few seconds.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
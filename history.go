@@ -0,0 +1,131 @@
+package tiwatch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrHistoryDisabled is returned by calls that require history mode.
+	ErrHistoryDisabled = errors.New("tiwatch: history mode disabled")
+	// ErrCompacted is returned when requested versions are no longer
+	// stored, see WithMaxHistoryDepth.
+	ErrCompacted = errors.New("tiwatch: version compacted")
+	// ErrHistoryTooLarge is returned by AllVersions for keys with more than
+	// MaxAllVersions stored versions.
+	ErrHistoryTooLarge = errors.New("tiwatch: history too large")
+)
+
+// MaxAllVersions is the most versions AllVersions returns for a key.
+var MaxAllVersions = 1000
+
+// VersionedValue is a value of a key along with its version.
+type VersionedValue struct {
+	Version int64
+	Val     string
+}
+
+// AllVersions returns every stored version of key, oldest first, in one
+// query. Without history mode that's only the current one. Keys with more than
+// MaxAllVersions versions fail with ErrHistoryTooLarge rather than loading an
+// unbounded history, bound it with WithMaxHistoryDepth. A key that doesn't
+// exist has no versions.
+func (b *TiWatch) AllVersions(key string) ([]VersionedValue, error) {
+	defer b.observe("history", key, time.Now())
+	rows, err := b.db.Query(b.tag("history")+fmt.Sprintf(`
+		SELECT
+			version, v
+		FROM
+			%s
+		WHERE k = ?
+		ORDER BY version
+		LIMIT ?
+	`, genTableName(b.ns)), key, MaxAllVersions+1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []VersionedValue
+	for rows.Next() {
+		var vv VersionedValue
+		if err := rows.Scan(&vv.Version, &vv.Val); err != nil {
+			return nil, err
+		}
+		if vv.Val, err = b.decode(vv.Val); err != nil {
+			return nil, err
+		}
+		versions = append(versions, vv)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) > MaxAllVersions {
+		return nil, ErrHistoryTooLarge
+	}
+	return versions, nil
+}
+
+// Replay sends the versions fromVersion to toVersion, both included, of key
+// into out as TypeUpdate events, in order, e.g. to rebuild a downstream from a
+// known range. It blocks while out is full, and returns ctx.Err() if ctx is
+// done first. It requires history mode.
+// Replay is all or nothing on compaction: if versions from fromVersion on
+// aren't stored anymore, it returns ErrCompacted without sending anything.
+// Versions past the latest one are simply not sent.
+func (b *TiWatch) Replay(ctx context.Context, key string, fromVersion, toVersion int64, out chan<- Op) error {
+	if !b.history {
+		return ErrHistoryDisabled
+	}
+	// the queries only, not the sends to out
+	start := time.Now()
+	var oldest sql.NullInt64
+	err := b.db.QueryRowContext(ctx, b.tag("history")+fmt.Sprintf(`
+		SELECT
+			MIN(version)
+		FROM
+			%s
+		WHERE k = ?
+	`, genTableName(b.ns)), key).Scan(&oldest)
+	if err != nil {
+		return err
+	}
+	if !oldest.Valid {
+		return nil
+	}
+	if oldest.Int64 > fromVersion {
+		return ErrCompacted
+	}
+
+	rows, err := b.db.QueryContext(ctx, b.tag("history")+fmt.Sprintf(`
+		SELECT
+			v
+		FROM
+			%s
+		WHERE k = ? AND version BETWEEN ? AND ?
+		ORDER BY version
+	`, genTableName(b.ns)), key, fromVersion, toVersion)
+	b.observe("history", key, start)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return err
+		}
+		if value, err = b.decode(value); err != nil {
+			return err
+		}
+		select {
+		case out <- Op{Type: TypeUpdate, Key: key, Val: value}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return rows.Err()
+}
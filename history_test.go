@@ -0,0 +1,35 @@
+package tiwatch
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Deduped writes leave gaps in the versions of a key, WithMaxHistoryDepth
+// still keeps the newest n rows.
+func TestDedupHistoryMaxDepth(t *testing.T) {
+	b := newTestWatch(t, WithHistory(), WithDedupHistory(), WithMaxHistoryDepth(3))
+	for _, value := range []string{"a", "b", "b", "b", "c", "d"} {
+		if err := b.Set("k", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	versions, err := b.AllVersions("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VersionedValue{{4, "b"}, {5, "c"}, {6, "d"}}
+	if !reflect.DeepEqual(versions, want) {
+		t.Fatalf("got %v, want %v", versions, want)
+	}
+	if err := b.Set("k", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if versions, err = b.AllVersions("k"); err != nil {
+		t.Fatal(err)
+	}
+	want = []VersionedValue{{4, "b"}, {5, "c"}, {7, "d"}}
+	if !reflect.DeepEqual(versions, want) {
+		t.Fatalf("got %v, want %v", versions, want)
+	}
+}
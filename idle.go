@@ -0,0 +1,218 @@
+package tiwatch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/c4pt0r/log"
+)
+
+// idleSweepBatch is the most keys a sweep of idle keys deletes per statement.
+const idleSweepBatch = 1000
+
+// WithIdleTTL makes the namespace behave like a cache: keys that are neither
+// read nor written for ttl are deleted, and their watchers get a TypeDelete.
+// Access times are kept in a last_access column, by the clock of TiDB. Writes
+// set it as part of the write; reads only record the key in memory, and a
+// background loop updates the last_access of all keys read since its last run
+// in one statement, then deletes the idle keys. The loop runs every tenth of
+// ttl, at most every minute, so reads cost no extra write each, at the price
+// of expiry being accurate to that interval. A key read through another
+// client that didn't flush its reads yet may expire, keep ttl well above the
+// interval.
+// The option adds the last_access column to the table, see Init about schema
+// options, and an index on it, which Init creates if it's missing, so that
+// sweeps don't scan the whole table.
+func WithIdleTTL(ttl time.Duration) Option {
+	return func(b *TiWatch) {
+		b.idleTTL = ttl
+		b.accessed = make(map[string]struct{})
+	}
+}
+
+// touch records a read of key, to be flushed by sweepIdle.
+func (b *TiWatch) touch(key string) {
+	if b.idleTTL <= 0 {
+		return
+	}
+	b.accessMu.Lock()
+	b.accessed[key] = struct{}{}
+	b.accessMu.Unlock()
+}
+
+// sweepIdle flushes recorded reads and deletes idle keys until b is closed.
+func (b *TiWatch) sweepIdle() {
+	interval := b.idleTTL / 10
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	for sleep(interval, b.closed) {
+		if err := b.flushAccesses(); err != nil {
+			log.Error(err)
+		}
+		if err := b.deleteIdle(); err != nil {
+			log.Error(err)
+		}
+	}
+}
+
+func (b *TiWatch) flushAccesses() error {
+	b.accessMu.Lock()
+	accessed := b.accessed
+	b.accessed = make(map[string]struct{})
+	b.accessMu.Unlock()
+
+	keys := make([]interface{}, 0, idleSweepBatch)
+	for key := range accessed {
+		keys = append(keys, key)
+		if len(keys) == idleSweepBatch {
+			if err := b.touchKeys(keys); err != nil {
+				return err
+			}
+			keys = keys[:0]
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return b.touchKeys(keys)
+}
+
+func (b *TiWatch) touchKeys(keys []interface{}) error {
+	defer b.observe("touch", "", time.Now())
+	_, err := b.db.Exec(b.tag("touch")+fmt.Sprintf(`
+		UPDATE
+			%s
+		SET last_access = NOW()
+		WHERE k IN (%s)
+	`, genTableName(b.ns), placeholders(len(keys))), keys...)
+	return err
+}
+
+// deleteIdle deletes every key whose latest access is older than the TTL.
+// It finds candidates through the index on last_access, in pages ordered by
+// it, so a sweep reads the idle rows only, not the whole table.
+func (b *TiWatch) deleteIdle() error {
+	var (
+		// last row of the previous page
+		afterAccess, afterKey string
+		first                 = true
+	)
+	for {
+		rows, err := b.db.Query(b.tag("expire")+fmt.Sprintf(`
+			SELECT
+				k, last_access
+			FROM
+				%s
+			WHERE last_access < NOW() - INTERVAL ? SECOND
+				AND (? OR last_access > ? OR (last_access = ? AND k > ?))
+			ORDER BY last_access, k
+			LIMIT ?
+		`, genTableName(b.ns)), int64(b.idleTTL/time.Second), first, afterAccess, afterAccess, afterKey, idleSweepBatch)
+		if err != nil {
+			return err
+		}
+		// in history mode a key has a row per version, and it's idle only if
+		// all of them are, which deleteIdleKeys checks
+		var keys []string
+		seen := make(map[string]bool)
+		n := 0
+		for rows.Next() {
+			if err := rows.Scan(&afterKey, &afterAccess); err != nil {
+				rows.Close()
+				return err
+			}
+			n++
+			if !seen[afterKey] {
+				seen[afterKey] = true
+				keys = append(keys, afterKey)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		first = false
+		if len(keys) > 0 {
+			if err := b.deleteIdleKeys(keys); err != nil {
+				return err
+			}
+		}
+		if n < idleSweepBatch {
+			return nil
+		}
+	}
+}
+
+// deleteIdleKeys deletes those of keys that are still idle once locked:
+// a write may have refreshed last_access since they were selected.
+func (b *TiWatch) deleteIdleKeys(keys []string) error {
+	defer b.observe("expire", "", time.Now())
+	txn, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	if err := b.lockKeys(txn, keys); err != nil {
+		return err
+	}
+	args := make([]interface{}, 0, len(keys)+1)
+	args = append(args, int64(b.idleTTL/time.Second))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	// a locking read, to see the writes committed after txn started
+	rows, err := txn.Query(b.tag("expire")+fmt.Sprintf(`
+		SELECT
+			k, last_access < NOW() - INTERVAL ? SECOND
+		FROM
+			%s
+		WHERE k IN (%s)
+		FOR UPDATE
+	`, genTableName(b.ns), placeholders(len(keys))), args...)
+	if err != nil {
+		return err
+	}
+	// in history mode a key is idle if all of its rows are
+	idle := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var (
+			key     string
+			rowIdle bool
+		)
+		if err := rows.Scan(&key, &rowIdle); err != nil {
+			rows.Close()
+			return err
+		}
+		if ok, seen := idle[key]; !seen || ok {
+			idle[key] = rowIdle
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	args = args[:0]
+	for key, ok := range idle {
+		if ok {
+			args = append(args, key)
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	_, err = txn.Exec(b.tag("expire")+fmt.Sprintf(`
+		DELETE FROM
+			%s
+		WHERE k IN (%s)
+	`, genTableName(b.ns), placeholders(len(args))), args...)
+	if err != nil {
+		return err
+	}
+	return txn.Commit()
+}
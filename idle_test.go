@@ -0,0 +1,58 @@
+package tiwatch
+
+import (
+	"testing"
+	"time"
+)
+
+// A sweep deletes the keys idle for the TTL, through the last_access index,
+// and only those: in history mode a key with a fresh row isn't idle.
+func TestDeleteIdle(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"latest", nil},
+		{"history", []Option{WithHistory()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestWatch(t, append(tc.opts, WithIdleTTL(time.Hour))...)
+			for _, key := range []string{"idle", "fresh", "partly"} {
+				if err := b.Set(key, "1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			table := genTableName(b.ns)
+			if _, err := b.DB().Exec("UPDATE " + table + " SET last_access = NOW() - INTERVAL 2 HOUR WHERE k IN ('idle', 'partly')"); err != nil {
+				t.Fatal(err)
+			}
+			if b.history {
+				// a fresh row of a key whose first row is idle
+				if err := b.Set("partly", "2"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var indexes int
+			err := b.DB().QueryRow(`
+				SELECT COUNT(*) FROM information_schema.STATISTICS
+				WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = 'idx_last_access'
+			`, table).Scan(&indexes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if indexes == 0 {
+				t.Fatal("no index on last_access")
+			}
+
+			if err := b.deleteIdle(); err != nil {
+				t.Fatal(err)
+			}
+			want := map[string]bool{"idle": false, "fresh": true, "partly": b.history}
+			for key, want := range want {
+				if ok, err := b.Exists(key); err != nil || ok != want {
+					t.Fatalf("%s exists %v, %v, want %v", key, ok, err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+//go:build go1.23
+
+package tiwatch
+
+import (
+	"context"
+	"iter"
+)
+
+// Events returns the watch events of key as an iterator, for use with for
+// range. The watch starts when the loop does, and stops when the loop breaks
+// or ctx is done. Polling errors are only logged, see EventsWithErrors to
+// receive them.
+func (b *TiWatch) Events(ctx context.Context, key string) iter.Seq[Op] {
+	return func(yield func(Op) bool) {
+		for op, err := range b.EventsWithErrors(ctx, key) {
+			if err != nil {
+				continue
+			}
+			if !yield(op) {
+				return
+			}
+		}
+	}
+}
+
+// EventsWithErrors is Events, also yielding polling errors, with a zero Op.
+// They don't end the iteration, the watcher keeps retrying.
+func (b *TiWatch) EventsWithErrors(ctx context.Context, key string) iter.Seq2[Op, error] {
+	return func(yield func(Op, error) bool) {
+		stop := make(chan struct{})
+		defer close(stop)
+		ch := make(chan Op)
+		errs := make(chan error, 1)
+		go b.watch(key, unseeded, ch, stop, errs, nil)
+		for {
+			select {
+			case op, ok := <-ch:
+				if !ok || !yield(op, nil) {
+					return
+				}
+			case err := <-errs:
+				if !yield(Op{}, err) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+func (s *ScopedWatch) Events(ctx context.Context, key string) iter.Seq[Op] {
+	return func(yield func(Op) bool) {
+		for op := range s.tw.Events(ctx, s.full(key)) {
+			op.Key = key
+			if !yield(op) {
+				return
+			}
+		}
+	}
+}
+
+func (s *ScopedWatch) EventsWithErrors(ctx context.Context, key string) iter.Seq2[Op, error] {
+	return func(yield func(Op, error) bool) {
+		for op, err := range s.tw.EventsWithErrors(ctx, s.full(key)) {
+			if err == nil {
+				op.Key = key
+			}
+			if !yield(op, err) {
+				return
+			}
+		}
+	}
+}
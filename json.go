@@ -0,0 +1,52 @@
+package tiwatch
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrInvalidJSON is returned by the writes of a value that isn't valid
+	// JSON when JSON values are enabled.
+	ErrInvalidJSON = errors.New("tiwatch: invalid JSON value")
+)
+
+// WithJSONValues stores values in a native TiDB JSON column instead of a
+// VARCHAR, which allows querying into them server side with GetJSONPath.
+// Writes reject values that aren't valid JSON with ErrInvalidJSON, and Get
+// returns values as normalized by TiDB, which may differ in whitespace and
+// key order from what was written.
+// It changes the type of the value column, see Init about schema options.
+func WithJSONValues() Option {
+	return func(b *TiWatch) {
+		b.jsonValues = true
+	}
+}
+
+// GetJSONPath returns the JSON text at path (e.g. `$.spec.replicas`) inside
+// the value of key, using JSON_EXTRACT, or an empty string if path matches
+// nothing. It returns ErrKeyNotFound if key doesn't exist.
+// It requires WithJSONValues.
+func (b *TiWatch) GetJSONPath(key, path string) (string, error) {
+	defer b.observe("get", key, time.Now())
+	var value sql.NullString
+	err := b.db.QueryRow(b.tag("get")+fmt.Sprintf(`
+		SELECT
+			JSON_EXTRACT(v, ?)
+		FROM
+			%s
+		WHERE
+			k = ?
+		ORDER BY version DESC
+		LIMIT 1
+	`, genTableName(b.ns)), path, key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrKeyNotFound
+		}
+		return "", err
+	}
+	return value.String, nil
+}
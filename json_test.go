@@ -0,0 +1,66 @@
+package tiwatch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Every write of JSON values rejects invalid JSON, and none limits their size
+// to that of a VARCHAR column.
+func TestJSONValuesWrites(t *testing.T) {
+	long := `"` + strings.Repeat("x", 2*maxValueLen) + `"`
+	for _, tc := range []struct {
+		name  string
+		write func(b *TiWatch, value string) error
+	}{
+		{"set", func(b *TiWatch, value string) error { return b.Set("k", value) }},
+		{"append", func(b *TiWatch, value string) error {
+			_, err := b.Append("k", value, "")
+			return err
+		}},
+		{"bulk load", func(b *TiWatch, value string) error {
+			return b.BulkLoad(func(yield func(key, value string) bool) { yield("k", value) })
+		}},
+		{"seed", func(b *TiWatch, value string) error {
+			return b.InitWithSeed(map[string]string{"k": value})
+		}},
+		{"claim", func(b *TiWatch, value string) error {
+			if err := b.Set("k", `"pending"`); err != nil {
+				return err
+			}
+			key, _, ok, err := b.ClaimOne("", `"pending"`, value)
+			if err == nil && (!ok || key != "k") {
+				return fmt.Errorf("claimed %q, %v", key, ok)
+			}
+			return err
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestWatch(t, WithJSONValues())
+			if err := tc.write(b, "{not json"); !errors.Is(err, ErrInvalidJSON) {
+				t.Fatalf("invalid JSON: got %v, want ErrInvalidJSON", err)
+			}
+			if err := tc.write(b, long); err != nil {
+				t.Fatalf("long JSON: %v", err)
+			}
+		})
+	}
+}
+
+// ClaimOne and DeleteWhere compare JSON values as JSON, whatever their text.
+func TestJSONValuesMatch(t *testing.T) {
+	b := newTestWatch(t, WithJSONValues())
+	if err := b.Set("t/1", `{"state": "pending"}`); err != nil {
+		t.Fatal(err)
+	}
+	key, _, ok, err := b.ClaimOne("t/", `{"state":"pending"}`, `{"state":"done"}`)
+	if err != nil || !ok || key != "t/1" {
+		t.Fatalf("claimed %q, %v, %v", key, ok, err)
+	}
+	n, err := b.DeleteWhere("t/", "v = CAST(? AS JSON)", `{"state": "done"}`)
+	if err != nil || n != 1 {
+		t.Fatalf("deleted %d, %v", n, err)
+	}
+}
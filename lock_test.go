@@ -0,0 +1,68 @@
+package tiwatch
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Multi-key operations running concurrently on overlapping keys lock them in
+// the same order, so none of them fails with a deadlock.
+func TestMultiKeyOperationsNoDeadlock(t *testing.T) {
+	for _, history := range []bool{false, true} {
+		t.Run(fmt.Sprintf("history=%v", history), func(t *testing.T) {
+			opts := []Option{WithIdleTTL(time.Second)}
+			if history {
+				opts = append(opts, WithHistory())
+			}
+			b := newTestWatch(t, opts...)
+			const keys, rounds = 8, 20
+			key := func(i int) string { return fmt.Sprintf("s/%d", i%keys) }
+			seed := make(map[string]string, keys)
+			for i := 0; i < keys; i++ {
+				seed[key(i)] = "x"
+			}
+
+			ops := map[string]func(i int) error{
+				"set": func(i int) error {
+					return b.SetUnguarded(key(i*7), "x")
+				},
+				"delete where": func(i int) error {
+					_, err := b.DeleteWhere("s/", "v = ?", "y")
+					return err
+				},
+				"seed": func(i int) error {
+					// a new client, as when provisioning races other writers
+					c := New(os.Getenv(testDSNEnv), b.ns, opts...)
+					defer c.Close()
+					return c.InitWithSeed(seed)
+				},
+				"claim": func(i int) error {
+					_, _, _, err := b.ClaimOne("s/", "x", "y")
+					return err
+				},
+				"expire": func(i int) error {
+					return b.deleteIdleKeys([]string{key(i + 3), key(i), key(i + 5)})
+				},
+			}
+			var wg sync.WaitGroup
+			for name, op := range ops {
+				for w := 0; w < 2; w++ {
+					wg.Add(1)
+					go func(name string, op func(int) error, w int) {
+						defer wg.Done()
+						for i := 0; i < rounds; i++ {
+							if err := op(w*rounds + i); err != nil {
+								t.Errorf("%s: %v", name, err)
+								return
+							}
+						}
+					}(name, op, w)
+				}
+			}
+			wg.Wait()
+		})
+	}
+}
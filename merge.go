@@ -0,0 +1,33 @@
+package tiwatch
+
+import (
+	"sync"
+)
+
+// NamespacedOp is an Op tagged with the namespace it was watched in.
+type NamespacedOp struct {
+	Namespace string
+	Op
+}
+
+// MergeWatch fans in watch channels, keyed by their namespace, into a single
+// channel. Every Op is tagged with the namespace of the channel it came from.
+// The returned channel is closed once all of the input channels are closed.
+func MergeWatch(watchers map[string]<-chan Op) <-chan NamespacedOp {
+	out := make(chan NamespacedOp)
+	var wg sync.WaitGroup
+	wg.Add(len(watchers))
+	for ns, ch := range watchers {
+		go func(ns string, ch <-chan Op) {
+			defer wg.Done()
+			for op := range ch {
+				out <- NamespacedOp{Namespace: ns, Op: op}
+			}
+		}(ns, ch)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
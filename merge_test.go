@@ -0,0 +1,47 @@
+package tiwatch
+
+import (
+	"testing"
+)
+
+func TestMergeWatch(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ops  map[string][]Op
+	}{
+		{"none", nil},
+		{"one", map[string][]Op{"a": {{Type: TypeUpdate, Key: "k", Val: "1", Seq: 1}}}},
+		{"many", map[string][]Op{
+			"a": {{Type: TypeUpdate, Key: "k", Val: "1", Seq: 1}, {Type: TypeDelete, Key: "k", Seq: 2}},
+			"b": {{Type: TypeUpdate, Key: "k", Val: "2", Seq: 1}},
+			"c": nil,
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			watchers := make(map[string]<-chan Op)
+			for ns, ops := range tc.ops {
+				ch := make(chan Op, len(ops))
+				for _, op := range ops {
+					ch <- op
+				}
+				close(ch)
+				watchers[ns] = ch
+			}
+			got := make(map[string][]Op)
+			for op := range MergeWatch(watchers) {
+				got[op.Namespace] = append(got[op.Namespace], op.Op)
+			}
+			for ns, ops := range tc.ops {
+				// in the order of their namespace
+				if len(got[ns]) != len(ops) {
+					t.Fatalf("%s: got %+v, want %+v", ns, got[ns], ops)
+				}
+				for i := range ops {
+					if got[ns][i] != ops[i] {
+						t.Fatalf("%s: got %+v, want %+v", ns, got[ns], ops)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package tiwatch
+
+import (
+	"expvar"
+	"sync"
+	"sync/atomic"
+
+	"github.com/c4pt0r/log"
+)
+
+// Metrics is a snapshot of the watch counters of a TiWatch.
+type Metrics struct {
+	// Polls is the number of version polls made by watchers.
+	Polls int64
+	// Events is the number of events delivered to watchers.
+	Events int64
+	// Errors is the number of failed watcher queries.
+	Errors int64
+	// Watchers is the number of running watchers.
+	Watchers int
+}
+
+// Metrics returns the current watch counters of b.
+func (b *TiWatch) Metrics() Metrics {
+	return Metrics{
+		Polls:    atomic.LoadInt64(&b.polls),
+		Events:   atomic.LoadInt64(&b.events),
+		Errors:   atomic.LoadInt64(&b.errs),
+		Watchers: b.TrackedVersions(),
+	}
+}
+
+// WithExpvar publishes the Metrics of the instance with the standard expvar
+// package when Init runs, so they show up on /debug/vars without any other
+// dependency. The variable is named after the namespace table, e.g.
+// "tiwatch_default"; only the first instance of a namespace in a process gets
+// to publish it.
+func WithExpvar() Option {
+	return func(b *TiWatch) {
+		b.expvar = true
+	}
+}
+
+// expvarMu serializes publishing, expvar.Publish panics on duplicate names.
+var expvarMu sync.Mutex
+
+func (b *TiWatch) publishExpvar() {
+	expvarMu.Lock()
+	defer expvarMu.Unlock()
+	name := genTableName(b.ns)
+	if expvar.Get(name) != nil {
+		log.Warnf("expvar %s is already published", name)
+		return
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return b.Metrics()
+	}))
+}
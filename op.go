@@ -0,0 +1,140 @@
+package tiwatch
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// opFormatVersion is the version of the Op wire formats. It is bumped when
+// fields are added; decoders ignore fields they don't know about, and leave
+// fields missing from older encodings at their zero value.
+const opFormatVersion = 2
+
+var errShortOp = errors.New("tiwatch: truncated binary Op")
+
+func (t OpType) String() string {
+	switch t {
+	case TypeDelete:
+		return "delete"
+	case TypeUpdate:
+		return "update"
+	}
+	return fmt.Sprintf("OpType(%d)", int(t))
+}
+
+func parseOpType(s string) (OpType, error) {
+	switch s {
+	case "delete":
+		return TypeDelete, nil
+	case "update":
+		return TypeUpdate, nil
+	}
+	return 0, fmt.Errorf("tiwatch: unknown op type %q", s)
+}
+
+// jsonOp is the JSON encoding of an Op:
+//
+//	{"format":2,"type":"update","key":"k","val":"v","seq":1}
+//
+// Format 1 had no seq.
+type jsonOp struct {
+	Format int    `json:"format"`
+	Type   string `json:"type"`
+	Key    string `json:"key"`
+	Val    string `json:"val,omitempty"`
+	Seq    uint64 `json:"seq,omitempty"`
+}
+
+// MarshalJSON encodes op as a JSON object, see jsonOp.
+func (op Op) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonOp{
+		Format: opFormatVersion,
+		Type:   op.Type.String(),
+		Key:    op.Key,
+		Val:    op.Val,
+		Seq:    op.Seq,
+	})
+}
+
+// UnmarshalJSON decodes an Op encoded by MarshalJSON.
+func (op *Op) UnmarshalJSON(data []byte) error {
+	var j jsonOp
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	t, err := parseOpType(j.Type)
+	if err != nil {
+		return err
+	}
+	*op = Op{Type: t, Key: j.Key, Val: j.Val, Seq: j.Seq}
+	return nil
+}
+
+// MarshalBinary encodes op as the format version byte followed by the fields
+// in order: the type as an uvarint, then the key and the value, each as an
+// uvarint length and its bytes, then, since format 2, the seq as an uvarint.
+// Fields added later are appended.
+func (op Op) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 1+4*binary.MaxVarintLen64+len(op.Key)+len(op.Val))
+	buf = append(buf, opFormatVersion)
+	buf = appendUvarint(buf, uint64(op.Type))
+	buf = appendString(buf, op.Key)
+	buf = appendString(buf, op.Val)
+	buf = appendUvarint(buf, op.Seq)
+	return buf, nil
+}
+
+// UnmarshalBinary decodes an Op encoded by MarshalBinary, of this or any later
+// format version.
+func (op *Op) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] == 0 {
+		return errShortOp
+	}
+	format := data[0]
+	data = data[1:]
+	t, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errShortOp
+	}
+	data = data[n:]
+	key, data, err := readString(data)
+	if err != nil {
+		return err
+	}
+	val, data, err := readString(data)
+	if err != nil {
+		return err
+	}
+	*op = Op{Type: OpType(t), Key: key, Val: val}
+	if format == 1 {
+		return nil
+	}
+	seq, n := binary.Uvarint(data)
+	if n <= 0 {
+		return errShortOp
+	}
+	op.Seq = seq
+	return nil
+}
+
+func appendUvarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = appendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(data []byte) (string, []byte, error) {
+	l, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < l {
+		return "", nil, errShortOp
+	}
+	data = data[n:]
+	return string(data[:l]), data[l:], nil
+}
@@ -0,0 +1,117 @@
+package tiwatch
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var testOps = []Op{
+	{Type: TypeUpdate, Key: "k", Val: "v", Seq: 1},
+	{Type: TypeDelete, Key: "k", Seq: 2},
+	{Type: TypeUpdate, Key: "", Val: "", Seq: 0},
+	{Type: TypeUpdate, Key: "ключ", Val: "{\"a\":[1,2]}\n", Seq: 1 << 40},
+}
+
+func TestOpBinaryRoundTrip(t *testing.T) {
+	for _, op := range testOps {
+		data, err := op.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Op
+		if err := got.UnmarshalBinary(data); err != nil {
+			t.Fatalf("%+v: %v", op, err)
+		}
+		if got != op {
+			t.Fatalf("got %+v, want %+v", got, op)
+		}
+	}
+}
+
+func TestOpUnmarshalBinary(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		data []byte
+		want Op
+		err  error
+	}{
+		{"format 1", []byte{1, 1, 1, 'k', 1, 'v'}, Op{Type: TypeUpdate, Key: "k", Val: "v"}, nil},
+		{"later format", []byte{3, 1, 1, 'k', 1, 'v', 7, 42}, Op{Type: TypeUpdate, Key: "k", Val: "v", Seq: 7}, nil},
+		{"empty", nil, Op{}, errShortOp},
+		{"format 0", []byte{0, 1, 1, 'k', 1, 'v', 7}, Op{}, errShortOp},
+		{"no type", []byte{2}, Op{}, errShortOp},
+		{"short key", []byte{2, 1, 3, 'k'}, Op{}, errShortOp},
+		{"no value", []byte{2, 1, 1, 'k'}, Op{}, errShortOp},
+		{"no seq", []byte{2, 1, 1, 'k', 1, 'v'}, Op{}, errShortOp},
+		{"short seq", []byte{2, 1, 1, 'k', 1, 'v', 0x80}, Op{}, errShortOp},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Op
+			err := got.UnmarshalBinary(tc.data)
+			if err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			if err == nil && got != tc.want {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+// Every truncation of an encoding fails rather than decoding another Op.
+func TestOpUnmarshalBinaryTruncated(t *testing.T) {
+	for _, op := range testOps {
+		data, err := op.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for n := 0; n < len(data); n++ {
+			var got Op
+			if err := got.UnmarshalBinary(data[:n]); err != errShortOp {
+				t.Fatalf("%+v truncated to %d bytes: got %+v, %v", op, n, got, err)
+			}
+		}
+	}
+}
+
+func TestOpJSONRoundTrip(t *testing.T) {
+	for _, op := range testOps {
+		data, err := json.Marshal(op)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Op
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if got != op {
+			t.Fatalf("got %+v, want %+v", got, op)
+		}
+	}
+}
+
+func TestOpUnmarshalJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		data    string
+		want    Op
+		wantErr bool
+	}{
+		{"format 1", `{"format":1,"type":"update","key":"k","val":"v"}`, Op{Type: TypeUpdate, Key: "k", Val: "v"}, false},
+		{"format 2", `{"format":2,"type":"delete","key":"k","seq":3}`, Op{Type: TypeDelete, Key: "k", Seq: 3}, false},
+		{"unknown field", `{"format":3,"type":"update","key":"k","seq":3,"extra":true}`, Op{Type: TypeUpdate, Key: "k", Seq: 3}, false},
+		{"unknown type", `{"format":2,"type":"put","key":"k"}`, Op{}, true},
+		{"truncated", `{"format":2,"type":"upd`, Op{}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got Op
+			err := json.Unmarshal([]byte(tc.data), &got)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+			if err == nil && got != tc.want {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package tiwatch
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// RatePolicy decides what RateLimit does with events over the rate.
+type RatePolicy int
+
+const (
+	// RateCoalesce holds events over the rate back, keeping only the latest
+	// one per key, so the consumer gets a bounded but current view.
+	RateCoalesce RatePolicy = iota
+	// RateDrop discards events over the rate.
+	RateDrop
+)
+
+// RateLimit forwards the events of in at most perSecond times a second, and
+// handles the events over that rate according to policy. With RateCoalesce
+// the stream becomes "the latest state of each key, at most perSecond events
+// a second", and no key is ever lost, only intermediate values; the Seq of
+// the skipped events shows as gaps in the forwarded ones. The returned
+// channel is closed after in is closed and the held back events, if any, are
+// delivered. It panics if perSecond isn't positive, like time.NewTicker.
+func RateLimit(in <-chan Op, perSecond float64, policy RatePolicy) <-chan Op {
+	if !(perSecond > 0) {
+		panic(fmt.Sprintf("tiwatch: non-positive rate %v for RateLimit", perSecond))
+	}
+	interval := time.Duration(math.MaxInt64)
+	if f := float64(time.Second) / perSecond; f < float64(math.MaxInt64) {
+		interval = time.Duration(f)
+	}
+	out := make(chan Op)
+	go func() {
+		defer close(out)
+		var (
+			// earliest time of the next delivery
+			next time.Time
+			// held back events, by key in order of arrival
+			pending = make(map[string]Op)
+			order   []string
+		)
+		for {
+			var (
+				send chan Op
+				head Op
+				wait <-chan time.Time
+			)
+			switch {
+			case len(order) > 0:
+				if d := time.Until(next); d > 0 {
+					wait = time.After(d)
+				} else {
+					send, head = out, pending[order[0]]
+				}
+			case in == nil:
+				return
+			}
+
+			select {
+			case op, ok := <-in:
+				if !ok {
+					in = nil
+					continue
+				}
+				if policy == RateDrop && (len(order) > 0 || time.Now().Before(next)) {
+					continue
+				}
+				if _, ok := pending[op.Key]; !ok {
+					order = append(order, op.Key)
+				}
+				pending[op.Key] = op
+			case send <- head:
+				delete(pending, order[0])
+				order = order[1:]
+				next = time.Now().Add(interval)
+			case <-wait:
+			}
+		}
+	}()
+	return out
+}
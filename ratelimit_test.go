@@ -0,0 +1,88 @@
+package tiwatch
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	ops := []Op{
+		{Type: TypeUpdate, Key: "a", Val: "1", Seq: 1},
+		{Type: TypeUpdate, Key: "b", Val: "1", Seq: 1},
+		{Type: TypeUpdate, Key: "a", Val: "2", Seq: 2},
+		{Type: TypeDelete, Key: "a", Seq: 3},
+		{Type: TypeUpdate, Key: "c", Val: "1", Seq: 1},
+	}
+	for _, tc := range []struct {
+		name   string
+		policy RatePolicy
+		want   []Op
+	}{
+		{"coalesce", RateCoalesce, []Op{
+			{Type: TypeDelete, Key: "a", Seq: 3},
+			{Type: TypeUpdate, Key: "b", Val: "1", Seq: 1},
+			{Type: TypeUpdate, Key: "c", Val: "1", Seq: 1},
+		}},
+		{"drop", RateDrop, []Op{
+			{Type: TypeUpdate, Key: "a", Val: "1", Seq: 1},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			in := make(chan Op)
+			const interval = 20 * time.Millisecond
+			out := RateLimit(in, float64(time.Second/interval), tc.policy)
+			// nothing is read from out yet, so all of ops arrive before the
+			// first delivery
+			for _, op := range ops {
+				in <- op
+			}
+			close(in)
+
+			var (
+				got  []Op
+				last time.Time
+			)
+			for op := range out {
+				if !last.IsZero() && time.Since(last) < interval-time.Millisecond {
+					t.Fatalf("%+v after %s, want at least %s", op, time.Since(last), interval)
+				}
+				last = time.Now()
+				got = append(got, op)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRateLimitInvalidRate(t *testing.T) {
+	for _, perSecond := range []float64{0, -1, math.NaN()} {
+		t.Run(fmt.Sprint(perSecond), func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Fatal("no panic")
+				}
+			}()
+			RateLimit(make(chan Op), perSecond, RateCoalesce)
+		})
+	}
+}
+
+// A rate too low for a time.Duration interval still delivers the first event.
+func TestRateLimitTinyRate(t *testing.T) {
+	in := make(chan Op, 2)
+	in <- Op{Type: TypeUpdate, Key: "a", Seq: 1}
+	in <- Op{Type: TypeUpdate, Key: "b", Seq: 1}
+	out := RateLimit(in, 1e-12, RateDrop)
+	if op := receive(t, out); op.Key != "a" {
+		t.Fatalf("got %+v", op)
+	}
+	close(in)
+	if op, ok := <-out; ok {
+		t.Fatalf("got %+v", op)
+	}
+}
@@ -0,0 +1,156 @@
+package tiwatch
+
+import (
+	"fmt"
+	"time"
+)
+
+// repairBatch is the most keys RepairAll looks at per query.
+const repairBatch = 1000
+
+// VersionChange is a row whose version Repair renumbered.
+type VersionChange struct {
+	Key  string
+	From int64
+	To   int64
+}
+
+// Repair renumbers the versions of key if they are inconsistent, e.g. after
+// manual changes to the table or when written by older releases, and returns
+// the rows it changed. Consistent versions are at least firstVersion, and in
+// history mode contiguous: the rows of a key are renumbered to consecutive
+// versions ending with the latest one, keeping their order, so watchers of
+// key see no change. WithMaxHistoryDepth trims the oldest rows, so the number
+// of rows doesn't tell the latest version, but keeps the rest contiguous.
+// WithDedupHistory bumps versions in place, leaving gaps that can't be told
+// from damage, so with it Repair only fixes versions below firstVersion.
+// Whenever versions would go below firstVersion they are shifted up, and
+// watchers see the latest value once more as an update.
+// Repair locks the rows of key for the duration of a single transaction.
+func (b *TiWatch) Repair(key string) ([]VersionChange, error) {
+	defer b.observe("repair", key, time.Now())
+	txn, err := b.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	rows, err := txn.Query(b.tag("repair")+fmt.Sprintf(`
+		SELECT
+			version
+		FROM
+			%s
+		WHERE k = ?
+		ORDER BY version
+		FOR UPDATE
+	`, genTableName(b.ns)), key)
+	if err != nil {
+		return nil, err
+	}
+	var versions []int64
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+
+	n := int64(len(versions))
+	target := func(i int64) int64 {
+		shift := firstVersion - versions[0]
+		if shift < 0 {
+			shift = 0
+		}
+		return versions[i] + shift
+	}
+	if b.contiguousVersions() {
+		latest := versions[n-1]
+		if latest < firstVersion+n-1 {
+			latest = firstVersion + n - 1
+		}
+		target = func(i int64) int64 {
+			return latest - (n - 1 - i)
+		}
+	}
+	var changes []VersionChange
+	// every row moves up, if at all, so renumbering from the latest row
+	// down never collides with a row yet to move
+	for i := n - 1; i >= 0; i-- {
+		to := target(i)
+		if versions[i] == to {
+			continue
+		}
+		_, err := txn.Exec(b.tag("repair")+fmt.Sprintf(`
+			UPDATE
+				%s
+			SET version = ?
+			WHERE k = ? AND version = ?
+		`, genTableName(b.ns)), to, key, versions[i])
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, VersionChange{Key: key, From: versions[i], To: to})
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	if err := txn.Commit(); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// contiguousVersions reports whether the versions of every key are meant to
+// be contiguous, see Repair.
+func (b *TiWatch) contiguousVersions() bool {
+	return !b.history || !b.dedupHistory || b.dedupKeepVersion
+}
+
+// RepairAll runs Repair on every key of the namespace with inconsistent
+// versions and returns all the rows it changed. Keys are found in batches
+// by an aggregate over the whole table, then repaired one transaction each,
+// so only one key is locked at a time.
+func (b *TiWatch) RepairAll() ([]VersionChange, error) {
+	var (
+		changes []VersionChange
+		after   string
+		// the first batch starts before any key, including ""
+		first = true
+	)
+	for {
+		keys, err := queryKeys(b.db.Query(b.tag("repair")+fmt.Sprintf(`
+			SELECT
+				k
+			FROM
+				%s
+			WHERE k > ? OR ?
+			GROUP BY k
+			HAVING MIN(version) < ? OR (? AND COUNT(*) <> MAX(version) - MIN(version) + 1)
+			ORDER BY k
+			LIMIT ?
+		`, genTableName(b.ns)), after, first, firstVersion, b.contiguousVersions(), repairBatch))
+		if err != nil {
+			return changes, err
+		}
+		for _, key := range keys {
+			c, err := b.Repair(key)
+			if err != nil {
+				return changes, err
+			}
+			changes = append(changes, c...)
+		}
+		if len(keys) < repairBatch {
+			return changes, nil
+		}
+		after, first = keys[len(keys)-1], false
+	}
+}
@@ -0,0 +1,98 @@
+package tiwatch
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrSchemaMismatch is returned by Init when the table of the namespace was
+// created with other schema options, see Init.
+var ErrSchemaMismatch = errors.New("tiwatch: table schema doesn't match options")
+
+// checkSchema compares the existing table of the namespace with what
+// createTables would create for the options of b.
+func (b *TiWatch) checkSchema() error {
+	table := genTableName(b.ns)
+	pk, err := queryKeys(b.db.Query(b.tag("init")+`
+		SELECT
+			COLUMN_NAME
+		FROM
+			information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = 'PRIMARY'
+		ORDER BY ORDINAL_POSITION
+	`, table))
+	if err != nil {
+		return err
+	}
+	wantPK := "k"
+	if b.history {
+		wantPK = "k, version"
+	}
+	if got := strings.Join(pk, ", "); got != wantPK {
+		return fmt.Errorf("%w: %s has primary key (%s), want (%s), check WithHistory", ErrSchemaMismatch, table, got, wantPK)
+	}
+
+	rows, err := b.db.Query(b.tag("init")+`
+		SELECT
+			COLUMN_NAME, DATA_TYPE
+		FROM
+			information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`, table)
+	if err != nil {
+		return err
+	}
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			rows.Close()
+			return err
+		}
+		columns[strings.ToLower(name)] = strings.ToLower(typ)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	wantType := "varchar"
+	if b.jsonValues {
+		wantType = "json"
+	}
+	if got := columns["v"]; got != wantType {
+		return fmt.Errorf("%w: %s has values of type %s, want %s, check WithJSONValues", ErrSchemaMismatch, table, got, wantType)
+	}
+	for _, c := range []struct {
+		column string
+		want   bool
+		option string
+	}{
+		{"last_access", b.idleTTL > 0, "WithIdleTTL"},
+		{"window_start", b.windowedCounters, "WithWindowedCounters"},
+	} {
+		if _, got := columns[c.column]; got != c.want {
+			return fmt.Errorf("%w: %s column %s: present %v, want %v, check %s", ErrSchemaMismatch, table, c.column, got, c.want, c.option)
+		}
+	}
+
+	var partitions int
+	err = b.db.QueryRow(b.tag("init")+`
+		SELECT
+			COUNT(*)
+		FROM
+			information_schema.PARTITIONS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND PARTITION_NAME IS NOT NULL
+	`, table).Scan(&partitions)
+	if err != nil {
+		return err
+	}
+	wantPartitions := 0
+	if b.partitions > 1 {
+		wantPartitions = b.partitions
+	}
+	if partitions != wantPartitions {
+		return fmt.Errorf("%w: %s has %d partitions, want %d, check WithPartitions", ErrSchemaMismatch, table, partitions, wantPartitions)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package tiwatch
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// Init of a namespace with other schema options than its table fails.
+func TestSchemaMismatch(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		created []Option
+		opened  []Option
+	}{
+		{"history", nil, []Option{WithHistory()}},
+		{"no history", []Option{WithHistory()}, nil},
+		{"json", nil, []Option{WithJSONValues()}},
+		{"idle ttl", nil, []Option{WithIdleTTL(time.Hour)}},
+		{"windowed counters", nil, []Option{WithWindowedCounters()}},
+		{"partitions", nil, []Option{WithPartitions(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestWatch(t, tc.created...)
+			other := New(os.Getenv(testDSNEnv), b.ns, tc.opened...)
+			defer other.Close()
+			if err := other.Init(); !errors.Is(err, ErrSchemaMismatch) {
+				t.Fatalf("got %v, want ErrSchemaMismatch", err)
+			}
+			same := New(os.Getenv(testDSNEnv), b.ns, tc.created...)
+			defer same.Close()
+			if err := same.Init(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
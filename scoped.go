@@ -0,0 +1,234 @@
+package tiwatch
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// ScopedWatch is a view of a TiWatch restricted to the keys under a prefix,
+// see Sub. Its methods are the ones of TiWatch, taking and returning keys
+// relative to the prefix, and share the connections of the parent.
+type ScopedWatch struct {
+	tw     *TiWatch
+	prefix string
+}
+
+// Sub returns a view of b whose keys are all under prefix + "/", e.g. key
+// "db" of b.Sub("services") is "services/db" in b.
+func (b *TiWatch) Sub(prefix string) *ScopedWatch {
+	return &ScopedWatch{tw: b, prefix: prefix + "/"}
+}
+
+// Sub returns a view of the keys under prefix + "/" within s.
+func (s *ScopedWatch) Sub(prefix string) *ScopedWatch {
+	return &ScopedWatch{tw: s.tw, prefix: s.prefix + prefix + "/"}
+}
+
+// Prefix returns the prefix of the keys of s in the parent TiWatch.
+func (s *ScopedWatch) Prefix() string {
+	return s.prefix
+}
+
+// KVSource returns the keys of s, for Diff.
+func (s *ScopedWatch) KVSource() KVSource {
+	return KVSource{W: s.tw, Prefix: s.prefix}
+}
+
+func (s *ScopedWatch) full(key string) string {
+	return s.prefix + key
+}
+
+func (s *ScopedWatch) fullKeys(keys []string) []string {
+	full := make([]string, len(keys))
+	for i, key := range keys {
+		full[i] = s.full(key)
+	}
+	return full
+}
+
+func (s *ScopedWatch) rel(key string) string {
+	return strings.TrimPrefix(key, s.prefix)
+}
+
+// relOps forwards the Ops of ch with keys relative to s.
+func (s *ScopedWatch) relOps(ch <-chan Op) <-chan Op {
+	out := make(chan Op)
+	go func() {
+		defer close(out)
+		for op := range ch {
+			op.Key = s.rel(op.Key)
+			out <- op
+		}
+	}()
+	return out
+}
+
+func (s *ScopedWatch) Get(key string) (string, bool, error) {
+	return s.tw.Get(s.full(key))
+}
+
+func (s *ScopedWatch) GetWithVersion(key string) (string, int64, bool, error) {
+	return s.tw.GetWithVersion(s.full(key))
+}
+
+func (s *ScopedWatch) GetLinearizable(ctx context.Context, key string) (string, bool, error) {
+	return s.tw.GetLinearizable(ctx, s.full(key))
+}
+
+func (s *ScopedWatch) GetJSONPath(key, path string) (string, error) {
+	return s.tw.GetJSONPath(s.full(key), path)
+}
+
+func (s *ScopedWatch) Exists(key string) (bool, error) {
+	return s.tw.Exists(s.full(key))
+}
+
+func (s *ScopedWatch) MExists(keys []string) (map[string]bool, error) {
+	exists, err := s.tw.MExists(s.fullKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+	rel := make(map[string]bool, len(exists))
+	for key, ok := range exists {
+		rel[s.rel(key)] = ok
+	}
+	return rel, nil
+}
+
+func (s *ScopedWatch) MGetVersions(keys []string) (map[string]int64, error) {
+	versions, err := s.tw.MGetVersions(s.fullKeys(keys))
+	if err != nil {
+		return nil, err
+	}
+	rel := make(map[string]int64, len(versions))
+	for key, version := range versions {
+		rel[s.rel(key)] = version
+	}
+	return rel, nil
+}
+
+func (s *ScopedWatch) AllVersions(key string) ([]VersionedValue, error) {
+	return s.tw.AllVersions(s.full(key))
+}
+
+func (s *ScopedWatch) Set(key string, value string) error {
+	return s.tw.Set(s.full(key), value)
+}
+
+func (s *ScopedWatch) SetUnguarded(key string, value string) error {
+	return s.tw.SetUnguarded(s.full(key), value)
+}
+
+func (s *ScopedWatch) SetWithoutLock(key string, value string) error {
+	return s.tw.SetWithoutLock(s.full(key), value)
+}
+
+func (s *ScopedWatch) SetIfVersion(key string, value string, expectedVersion int64) (bool, error) {
+	return s.tw.SetIfVersion(s.full(key), value, expectedVersion)
+}
+
+func (s *ScopedWatch) Append(key string, suffix string, sep string) (string, error) {
+	return s.tw.Append(s.full(key), suffix, sep)
+}
+
+func (s *ScopedWatch) GetAndSet(key string, newVal string) (string, bool, error) {
+	return s.tw.GetAndSet(s.full(key), newVal)
+}
+
+func (s *ScopedWatch) IncrWindowed(key string, delta int64, window time.Duration) (int64, error) {
+	return s.tw.IncrWindowed(s.full(key), delta, window)
+}
+
+func (s *ScopedWatch) BulkLoad(pairs func(yield func(key, value string) bool), opts ...BulkLoadOption) error {
+	return s.tw.BulkLoad(func(yield func(key, value string) bool) {
+		pairs(func(key, value string) bool {
+			return yield(s.full(key), value)
+		})
+	}, opts...)
+}
+
+func (s *ScopedWatch) Delete(key string) error {
+	return s.tw.Delete(s.full(key))
+}
+
+func (s *ScopedWatch) DeleteIfVersion(key string, expectedVersion int64) (bool, error) {
+	return s.tw.DeleteIfVersion(s.full(key), expectedVersion)
+}
+
+func (s *ScopedWatch) DeleteWhere(prefix string, valuePredicate string, args ...interface{}) (int64, error) {
+	return s.tw.DeleteWhere(s.full(prefix), valuePredicate, args...)
+}
+
+func (s *ScopedWatch) ClaimOne(prefix string, fromValue string, toValue string) (string, string, bool, error) {
+	key, val, ok, err := s.tw.ClaimOne(s.full(prefix), fromValue, toValue)
+	return s.rel(key), val, ok, err
+}
+
+func (s *ScopedWatch) Watch(key string) <-chan Op {
+	return s.relOps(s.tw.Watch(s.full(key)))
+}
+
+func (s *ScopedWatch) WatchWithAck(key string, redeliverAfter time.Duration) (<-chan Op, func(seq uint64)) {
+	ch, ack := s.tw.WatchWithAck(s.full(key), redeliverAfter)
+	return s.relOps(ch), ack
+}
+
+func (s *ScopedWatch) WatchMustExist(key string) (<-chan Op, error) {
+	ch, err := s.tw.WatchMustExist(s.full(key))
+	if err != nil {
+		return nil, err
+	}
+	return s.relOps(ch), nil
+}
+
+func (s *ScopedWatch) WatchConsistent(key string) (string, bool, <-chan Op, error) {
+	value, exists, ch, err := s.tw.WatchConsistent(s.full(key))
+	if err != nil {
+		return "", false, nil, err
+	}
+	return value, exists, s.relOps(ch), nil
+}
+
+func (s *ScopedWatch) WatchMany(keys []string) (map[string]<-chan Op, func()) {
+	chs, cancel := s.tw.WatchMany(s.fullKeys(keys))
+	rel := make(map[string]<-chan Op, len(chs))
+	for key, ch := range chs {
+		rel[s.rel(key)] = s.relOps(ch)
+	}
+	return rel, cancel
+}
+
+func (s *ScopedWatch) WaitForVersion(ctx context.Context, key string, minVersion int64, opts ...WaitOption) (int64, error) {
+	return s.tw.WaitForVersion(ctx, s.full(key), minVersion, opts...)
+}
+
+func (s *ScopedWatch) Replay(ctx context.Context, key string, fromVersion, toVersion int64, out chan<- Op) error {
+	full := make(chan Op)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.tw.Replay(ctx, s.full(key), fromVersion, toVersion, full)
+		close(full)
+	}()
+	for op := range full {
+		op.Key = key
+		select {
+		case out <- op:
+		case <-ctx.Done():
+			// unblock Replay, it returns on ctx too
+			for range full {
+			}
+			<-done
+			return ctx.Err()
+		}
+	}
+	return <-done
+}
+
+func (s *ScopedWatch) CurrentPollInterval(key string) (time.Duration, bool) {
+	return s.tw.CurrentPollInterval(s.full(key))
+}
+
+func (s *ScopedWatch) WaitForEmpty(ctx context.Context, prefix string) error {
+	return s.tw.WaitForEmpty(ctx, s.full(prefix))
+}
@@ -0,0 +1,90 @@
+package tiwatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// InitWithSeed is Init, then writes the seed keys in a single transaction,
+// unless the namespace is already seeded, i.e. any of the seed keys exists.
+// On a client Init already ran for, it only seeds.
+// It's safe for any number of clients to run it concurrently on a new
+// namespace: exactly one of them writes the seed and the others do nothing.
+// Their seed transactions lock the seed keys in the same order, so the others
+// find the seed already there, or, with WithHistory, where a missing key
+// can't be locked, fail to insert it and take that as the seed being there.
+// Readers and watchers see either none or all of the seed keys.
+func (b *TiWatch) InitWithSeed(seed map[string]string) error {
+	if err := b.Init(); err != nil {
+		return err
+	}
+	if len(seed) == 0 {
+		return nil
+	}
+	defer b.observe("seed", "", time.Now())
+	keys := make([]string, 0, len(seed))
+	for key, value := range seed {
+		if b.jsonValues && !json.Valid([]byte(value)) {
+			return fmt.Errorf("%w: key %q", ErrInvalidJSON, key)
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	txn, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	if err := b.lockKeys(txn, keys); err != nil {
+		return err
+	}
+	args := make([]interface{}, 0, 2*len(keys))
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	// a locking read, to see seeds committed after txn started
+	existing, err := queryKeys(txn.Query(b.tag("seed")+fmt.Sprintf(`
+		SELECT
+			k
+		FROM
+			%s
+		WHERE k IN (%s)
+		FOR UPDATE
+	`, genTableName(b.ns), placeholders(len(keys))), args...))
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		// already seeded
+		return nil
+	}
+
+	args = args[:0]
+	for _, key := range keys {
+		stored := b.encode(seed[key])
+		b.checkSize(key, stored)
+		args = append(args, key, stored, firstVersion)
+	}
+	_, err = txn.Exec(b.tag("seed")+fmt.Sprintf(`
+		INSERT INTO
+			%s (k, v, version)
+		VALUES %s
+	`, genTableName(b.ns), strings.TrimSuffix(strings.Repeat("(?, ?, ?), ", len(keys)), ", ")), args...)
+	if err != nil {
+		var merr *mysql.MySQLError
+		if errors.As(err, &merr) && merr.Number == errDupEntry {
+			// seeded concurrently
+			return nil
+		}
+		return err
+	}
+	return txn.Commit()
+}
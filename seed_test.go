@@ -0,0 +1,78 @@
+package tiwatch
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+)
+
+// Of concurrent InitWithSeed calls on a new namespace, exactly one writes the
+// seed and none fails.
+func TestInitWithSeedConcurrent(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"latest", nil},
+		{"history", []Option{WithHistory()}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestWatch(t, tc.opts...)
+			seed := map[string]string{"a": "1", "b": "2", "c": "3"}
+			const clients = 8
+			var wg sync.WaitGroup
+			for i := 0; i < clients; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					c := New(os.Getenv(testDSNEnv), b.ns, tc.opts...)
+					defer c.Close()
+					s := make(map[string]string, len(seed))
+					for k, v := range seed {
+						s[k] = fmt.Sprintf("%s-%d", v, i)
+					}
+					if err := c.InitWithSeed(s); err != nil {
+						t.Error(err)
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			var writer string
+			for k := range seed {
+				value, version, exists, err := b.GetWithVersion(k)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !exists || version != firstVersion {
+					t.Fatalf("%s: exists %v, version %d", k, exists, version)
+				}
+				var i int
+				if _, err := fmt.Sscanf(value, seed[k]+"-%d", &i); err != nil {
+					t.Fatalf("%s: %q", k, value)
+				}
+				if w := fmt.Sprint(i); writer == "" {
+					writer = w
+				} else if w != writer {
+					t.Fatalf("seed written by clients %s and %s", writer, w)
+				}
+			}
+		})
+	}
+}
+
+// InitWithSeed on an initialized client only seeds, it keeps its connections.
+func TestInitWithSeedInitialized(t *testing.T) {
+	b := newTestWatch(t)
+	db := b.DB()
+	if err := b.InitWithSeed(map[string]string{"a": "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if b.DB() != db {
+		t.Fatal("connections replaced")
+	}
+	if value, _, err := b.Get("a"); err != nil || value != "1" {
+		t.Fatalf("got %q, %v", value, err)
+	}
+}
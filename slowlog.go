@@ -0,0 +1,44 @@
+package tiwatch
+
+import (
+	"time"
+
+	"github.com/c4pt0r/log"
+)
+
+// WithSlowQueryThreshold reports every database operation of b that takes at
+// least d to the slow query handler, see WithSlowQueryHandler. It's off by
+// default.
+func WithSlowQueryThreshold(d time.Duration) Option {
+	return func(b *TiWatch) {
+		b.slowThreshold = d
+	}
+}
+
+// WithSlowQueryHandler sets the handler of slow operations, which logs a
+// warning by default. op names the operation, e.g. "get", "set" or "poll"
+// for a watcher poll, key is the key it was about, if any. The handler
+// runs after the operation finished, outside of its transaction, on the
+// goroutine of the caller, so it should return quickly.
+func WithSlowQueryHandler(fn func(op string, key string, dur time.Duration)) Option {
+	return func(b *TiWatch) {
+		b.slowHandler = fn
+	}
+}
+
+// observe reports the operation started at start if it was slow, use as
+// defer b.observe(op, key, time.Now()).
+func (b *TiWatch) observe(op string, key string, start time.Time) {
+	if b.slowThreshold <= 0 {
+		return
+	}
+	dur := time.Since(start)
+	if dur < b.slowThreshold {
+		return
+	}
+	if b.slowHandler != nil {
+		b.slowHandler(op, key, dur)
+		return
+	}
+	log.Warnf("slow %s %q: %s", op, key, dur)
+}
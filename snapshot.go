@@ -0,0 +1,141 @@
+package tiwatch
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrSnapshotTooOld is returned by the reads of a SnapshotClient whose
+// timestamp is older than the GC safe point of TiDB, so the versions it
+// would read may be gone.
+var ErrSnapshotTooOld = errors.New("tiwatch: snapshot older than GC safe point")
+
+// errGCTooEarly is the TiDB error of reads before the GC safe point.
+const errGCTooEarly = 9006
+
+// SnapshotClient reads the namespace as it was at a fixed timestamp, see
+// SnapshotSession.
+type SnapshotClient struct {
+	tw *TiWatch
+	ts time.Time
+}
+
+// SnapshotSession returns a client whose reads all see the namespace as of
+// ts, with TiDB's AS OF TIMESTAMP stale reads, so many calls over the course
+// of a long job see one consistent snapshot regardless of concurrent
+// writes. ts must be in the past and not older than the GC safe point,
+// tidb_gc_life_time ago by default, for the whole job: once GC passes it,
+// reads fail with ErrSnapshotTooOld. A snapshot has no changes to watch.
+func (b *TiWatch) SnapshotSession(ts time.Time) *SnapshotClient {
+	return &SnapshotClient{tw: b, ts: ts}
+}
+
+// Timestamp returns the timestamp the reads of s see.
+func (s *SnapshotClient) Timestamp() time.Time {
+	return s.ts
+}
+
+// asOf returns the AS OF clause of the reads of s.
+func (s *SnapshotClient) asOf() string {
+	// an instant, whatever the time zone of the session
+	return fmt.Sprintf("AS OF TIMESTAMP FROM_UNIXTIME(%d.%06d)", s.ts.Unix(), s.ts.Nanosecond()/1000)
+}
+
+func (s *SnapshotClient) Get(key string) (string, bool, error) {
+	defer s.tw.observe("get", key, time.Now())
+	var value string
+	err := s.tw.db.QueryRow(s.tw.tag("get")+fmt.Sprintf(`
+		SELECT
+			v
+		FROM
+			%s %s
+		WHERE k = ?
+		ORDER BY version DESC
+		LIMIT 1
+	`, genTableName(s.tw.ns), s.asOf()), key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, nil
+		}
+		return "", false, snapshotErr(err)
+	}
+	value, err = s.tw.decode(value)
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// MGet returns the values of those of keys that existed, in a single query.
+func (s *SnapshotClient) MGet(keys []string) (map[string]string, error) {
+	defer s.tw.observe("get", "", time.Now())
+	if len(keys) == 0 {
+		return map[string]string{}, nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	return s.latest(s.tw.db.Query(s.tw.tag("get")+fmt.Sprintf(`
+		SELECT
+			k, v
+		FROM
+			%s %s
+		WHERE k IN (%s)
+		ORDER BY k, version DESC
+	`, genTableName(s.tw.ns), s.asOf(), placeholders(len(keys))), args...))
+}
+
+// List returns all the keys under prefix and their values, in a single
+// query.
+func (s *SnapshotClient) List(prefix string) (map[string]string, error) {
+	defer s.tw.observe("list", prefix, time.Now())
+	return s.latest(s.tw.db.Query(s.tw.tag("list")+fmt.Sprintf(`
+		SELECT
+			k, v
+		FROM
+			%s %s
+		WHERE k LIKE ?
+		ORDER BY k, version DESC
+	`, genTableName(s.tw.ns), s.asOf()), likePrefix(prefix)))
+}
+
+// latest collects the latest value of every key of rows, ordered by key and
+// version, latest first.
+func (s *SnapshotClient) latest(rows *sql.Rows, err error) (map[string]string, error) {
+	if err != nil {
+		return nil, snapshotErr(err)
+	}
+	defer rows.Close()
+	values := make(map[string]string)
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, snapshotErr(err)
+		}
+		if _, ok := values[k]; ok {
+			// an older version
+			continue
+		}
+		if v, err = s.tw.decode(v); err != nil {
+			return nil, err
+		}
+		values[k] = v
+	}
+	if err := rows.Err(); err != nil {
+		return nil, snapshotErr(err)
+	}
+	return values, nil
+}
+
+func snapshotErr(err error) error {
+	var merr *mysql.MySQLError
+	if errors.As(err, &merr) && merr.Number == errGCTooEarly {
+		return fmt.Errorf("%w: %s", ErrSnapshotTooOld, merr.Message)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package tiwatch
+
+import (
+	"fmt"
+	"time"
+)
+
+// SizePercentiles describes a length distribution, in bytes.
+type SizePercentiles struct {
+	P50 int64
+	P90 int64
+	P99 int64
+	Max int64
+}
+
+// SizeStats is the key and value size distribution of a namespace.
+type SizeStats struct {
+	// Rows is the number of rows the distribution is computed over. In
+	// history mode every stored version is a row.
+	Rows  int64
+	Key   SizePercentiles
+	Value SizePercentiles
+}
+
+// SizeDistribution returns key and value length percentiles across the
+// namespace, computed by TiDB with APPROX_PERCENTILE in a single aggregate
+// query. The query scans the whole table, it's meant for occasional capacity
+// planning, not for a hot path.
+func (b *TiWatch) SizeDistribution() (SizeStats, error) {
+	defer b.observe("stats", "", time.Now())
+	var st SizeStats
+	err := b.db.QueryRow(b.tag("stats")+fmt.Sprintf(`
+		SELECT
+			COUNT(*),
+			IFNULL(APPROX_PERCENTILE(LENGTH(k), 50), 0),
+			IFNULL(APPROX_PERCENTILE(LENGTH(k), 90), 0),
+			IFNULL(APPROX_PERCENTILE(LENGTH(k), 99), 0),
+			IFNULL(MAX(LENGTH(k)), 0),
+			IFNULL(APPROX_PERCENTILE(LENGTH(v), 50), 0),
+			IFNULL(APPROX_PERCENTILE(LENGTH(v), 90), 0),
+			IFNULL(APPROX_PERCENTILE(LENGTH(v), 99), 0),
+			IFNULL(MAX(LENGTH(v)), 0)
+		FROM
+			%s
+	`, genTableName(b.ns))).Scan(
+		&st.Rows,
+		&st.Key.P50, &st.Key.P90, &st.Key.P99, &st.Key.Max,
+		&st.Value.P50, &st.Value.P90, &st.Value.P99, &st.Value.Max,
+	)
+	if err != nil {
+		return SizeStats{}, err
+	}
+	return st, nil
+}
+
+// StorageEstimate returns an estimate of the bytes the namespace takes on
+// disk, data and indexes, from information_schema.TABLES. TiDB derives these
+// sizes from table statistics, so the estimate lags behind recent writes:
+// fine for cost attribution and quotas, not for exact accounting. History mode
+// keeps all versions in the namespace table, so they are included.
+func (b *TiWatch) StorageEstimate() (int64, error) {
+	defer b.observe("stats", "", time.Now())
+	var size int64
+	err := b.db.QueryRow(b.tag("stats")+`
+		SELECT
+			IFNULL(SUM(DATA_LENGTH + INDEX_LENGTH), 0)
+		FROM
+			information_schema.TABLES
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`, genTableName(b.ns)).Scan(&size)
+	if err != nil {
+		return 0, err
+	}
+	return size, nil
+}
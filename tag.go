@@ -0,0 +1,30 @@
+package tiwatch
+
+import (
+	"strings"
+)
+
+// WithQueryTag prefixes every statement b sends with a SQL comment made from
+// format, so DBAs can attribute the load of b in TiDB's slow query log and
+// statement summary tables, which keep the comment in the query text. In
+// format, {ns} is replaced with the namespace and {op} with the operation
+// the statement is part of, e.g. with "tiwatch:{ns}:{op}" a watcher poll of
+// namespace "default" is tagged /* tiwatch:default:poll */. It's off by
+// default. To throttle b with TiDB resource control, bind the user of the DSN
+// to a resource group, e.g. ALTER USER ... RESOURCE GROUP.
+func WithQueryTag(format string) Option {
+	return func(b *TiWatch) {
+		b.queryTag = format
+	}
+}
+
+// tag returns the comment that prefixes the statements of op, see
+// WithQueryTag, or "" if tagging is off.
+func (b *TiWatch) tag(op string) string {
+	if b.queryTag == "" {
+		return ""
+	}
+	tag := strings.NewReplacer("{ns}", b.ns, "{op}", op).Replace(b.queryTag)
+	// the comment must not end early
+	return "/* " + strings.ReplaceAll(tag, "*/", "") + " */"
+}
@@ -0,0 +1,55 @@
+package tiwatch
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/c4pt0r/log"
+)
+
+// teeBuffer is the number of events TeeWatch holds for a slow writer.
+const teeBuffer = 1024
+
+// TeeWatch forwards every Op of ch unchanged, and also writes it to w as a
+// line of JSON, see Op.MarshalJSON, so the stream a consumer saw can be
+// captured and replayed. Writes happen on their own goroutine and never hold
+// up the stream: if w falls more than teeBuffer events behind, events are
+// dropped from the log, and write errors are logged. If w has a Flush method,
+// like a bufio.Writer, it's flushed whenever the writer has caught up.
+// The returned channel is closed once ch is.
+func TeeWatch(ch <-chan Op, w io.Writer) <-chan Op {
+	out := make(chan Op)
+	pending := make(chan Op, teeBuffer)
+	go func() {
+		enc := json.NewEncoder(w)
+		flusher, _ := w.(interface{ Flush() error })
+		for op := range pending {
+			if err := enc.Encode(op); err != nil {
+				log.Error(err)
+			}
+			if flusher != nil && len(pending) == 0 {
+				if err := flusher.Flush(); err != nil {
+					log.Error(err)
+				}
+			}
+		}
+	}()
+	go func() {
+		defer close(out)
+		defer close(pending)
+		dropped := 0
+		for op := range ch {
+			select {
+			case pending <- op:
+				if dropped > 0 {
+					log.Warnf("tee: dropped %d events, writer too slow", dropped)
+					dropped = 0
+				}
+			default:
+				dropped++
+			}
+			out <- op
+		}
+	}()
+	return out
+}
@@ -0,0 +1,71 @@
+package tiwatch
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// syncBuffer is a bytes.Buffer safe for the concurrent writes of TeeWatch
+// and the reads of a test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var lines []string
+	sc := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines
+}
+
+func TestTeeWatch(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ops  []Op
+	}{
+		{"none", nil},
+		{"some", testOps},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := make(chan Op, len(tc.ops))
+			for _, op := range tc.ops {
+				ch <- op
+			}
+			close(ch)
+			var w syncBuffer
+			var got []Op
+			for op := range TeeWatch(ch, &w) {
+				got = append(got, op)
+			}
+			if !reflect.DeepEqual(got, tc.ops) {
+				t.Fatalf("forwarded %+v, want %+v", got, tc.ops)
+			}
+			// the log is written on its own goroutine
+			eventually(t, "logged events", func() bool { return len(w.lines()) == len(tc.ops) })
+			for i, line := range w.lines() {
+				var op Op
+				if err := json.Unmarshal([]byte(line), &op); err != nil {
+					t.Fatalf("line %d %q: %v", i, line, err)
+				}
+				if op != tc.ops[i] {
+					t.Fatalf("logged %+v, want %+v", op, tc.ops[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package tiwatch
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testDSNEnv names the environment variable holding the DSN of the TiDB the
+// integration tests and benchmarks run against, they're skipped without it.
+const testDSNEnv = "TIWATCH_TEST_DSN"
+
+var testNamespaces int64
+
+// newTestWatch returns an initialized TiWatch on a fresh namespace of the
+// test TiDB, dropped when the test ends.
+func newTestWatch(tb testing.TB, opts ...Option) *TiWatch {
+	tb.Helper()
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		tb.Skip(testDSNEnv + " not set")
+	}
+	ns := fmt.Sprintf("test_%d_%d", time.Now().UnixNano(), atomic.AddInt64(&testNamespaces, 1))
+	b := New(dsn, ns, opts...)
+	if err := b.Init(); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if _, err := b.DB().Exec("DROP TABLE IF EXISTS " + genTableName(ns)); err != nil {
+			tb.Error(err)
+		}
+		b.Close()
+	})
+	return b
+}
@@ -1,18 +1,35 @@
 package tiwatch
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/c4pt0r/log"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 var (
 	PollDuration time.Duration = time.Second
 )
 
+var (
+	// ErrKeyNotFound is returned by calls that require key to exist.
+	ErrKeyNotFound = errors.New("tiwatch: key not found")
+	// ErrValueTooLong is returned when a value wouldn't fit the value column.
+	ErrValueTooLong = errors.New("tiwatch: value too long")
+	// ErrVersionRequired is returned by Set in WriteModeVersionGuarded.
+	ErrVersionRequired = errors.New("tiwatch: write requires an expected version")
+)
+
 // TiWatch, a PoC implementation of Etcd's important APIs: Watch, Get, Set
 // The core idea is:
 // 1. TiDB is a scalable database with **SQL** semantics.
@@ -25,9 +42,149 @@ type TiWatch struct {
 	dsn string
 	db  *sql.DB
 	ns  string
+	// validation error of New, returned by Init
+	err error
+	// result of the first Init
+	initOnce sync.Once
+	initErr  error
+	// dedicated pool for watch polls, see WithWatchPool
+	watchDB *sql.DB
+	// closed by Close, stops background goroutines
+	closed    chan struct{}
+	closeOnce sync.Once
+	// creates the Backoff of every retrying path, see WithBackoff
+	newBackoff func() Backoff
+
+	// running watchers, see CurrentPollInterval
+	watchersMu sync.Mutex
+	watchers   map[*watcher]struct{}
+	// number of running watch loops, each tracking the version of one key
+	tracked int64
+	// watch counters, see Metrics
+	polls  int64
+	events int64
+	errs   int64
+	// number of watchers currently retrying failed queries
+	degraded int64
+
+	history      bool
+	dedupHistory bool
+	// dedup without a version bump, see WithDedupHistoryKeepVersion
+	dedupKeepVersion bool
+	// number of versions history mode keeps per key, 0 keeps them all
+	maxHistoryDepth int
+	jsonValues      bool
+	codec           Codec
+	writeMode       WriteMode
+
+	partitions        int
+	bulkLoadBatchSize int
+	expvar            bool
+	poolSize          int
+	watchPoolSize     int
+
+	// idle expiry, see WithIdleTTL
+	idleTTL  time.Duration
+	accessMu sync.Mutex
+	accessed map[string]struct{}
+
+	// window_start column, see IncrWindowed
+	windowedCounters bool
+
+	sizeWarnFraction float64
+	sizeWarnHandler  func(key string, size int, limit int)
+
+	slowThreshold time.Duration
+	slowHandler   func(op string, key string, dur time.Duration)
+	// SQL comment format, see WithQueryTag
+	queryTag string
+
+	// connection state monitor, see ConnectionState
+	stateOnce sync.Once
+	stateMu   sync.Mutex
+	state     State
+	stateSubs []chan State
+	stateDone bool
+}
+
+// Option configures optional behaviour of a TiWatch, see New.
+type Option func(*TiWatch)
+
+// WithHistory turns on history mode: every Set appends a new row with the
+// next version instead of overwriting the previous one, so the table keeps
+// the full change feed of every key. Get and Watch always see the latest row.
+// It changes the primary key of the table, see Init about schema options.
+func WithHistory() Option {
+	return func(b *TiWatch) {
+		b.history = true
+	}
+}
+
+// WithDedupHistory keeps idempotent re-Sets from appending redundant history
+// rows: when the new value is equal to the latest stored value, Set bumps the
+// version of the latest row in place instead of appending a row. Watchers are
+// notified as for any other Set, and the skipped version shows as a gap in
+// the history of the key. See WithDedupHistoryKeepVersion to keep the version
+// as well. It has no effect without WithHistory.
+func WithDedupHistory() Option {
+	return func(b *TiWatch) {
+		b.dedupHistory = true
+	}
+}
+
+// WithDedupHistoryKeepVersion is WithDedupHistory, but a Set of the value
+// already stored is a no-op: the version doesn't change either, so watchers
+// see no event for it. It has no effect without WithHistory.
+func WithDedupHistoryKeepVersion() Option {
+	return func(b *TiWatch) {
+		b.dedupHistory = true
+		b.dedupKeepVersion = true
+	}
+}
+
+// WriteMode decides how a plain Set treats concurrent updates.
+type WriteMode int
+
+const (
+	// WriteModeLWW lets Set overwrite whatever is stored, last write wins.
+	WriteModeLWW WriteMode = iota
+	// WriteModeVersionGuarded makes Set fail with ErrVersionRequired, so
+	// every write has to go through SetIfVersion, or explicitly opt out of
+	// the guard with SetUnguarded. This turns accidental lost updates into
+	// errors, except across a delete: versions restart when a key is
+	// recreated, so a version read before the delete can match again, see
+	// SetIfVersion.
+	WriteModeVersionGuarded
+)
 
-	watchers map[string]chan string
-	versions map[string]int64
+// WithDefaultWriteMode sets the WriteMode of Set, WriteModeLWW by default.
+func WithDefaultWriteMode(mode WriteMode) Option {
+	return func(b *TiWatch) {
+		b.writeMode = mode
+	}
+}
+
+// WithPartitions splits the namespace table into n partitions by a hash of
+// the key, with TiDB's KEY partitioning, to spread a very large namespace
+// over n physical tables. All the rows of a key are in the same partition, so
+// every operation keeps its semantics; TiDB routes single key statements to
+// their partition and fans prefix scans out over all of them, including
+// across partitions in a transaction. It needs TiDB 7.0 or later, see Init
+// about schema options.
+func WithPartitions(n int) Option {
+	return func(b *TiWatch) {
+		b.partitions = n
+	}
+}
+
+// WithMaxHistoryDepth bounds history mode to the n most recent versions of
+// every key: each write deletes the rows older than that within its own
+// transaction, so the history never grows beyond n rows per key and needs no
+// separate compaction. It has no effect without WithHistory.
+func WithMaxHistoryDepth(n int) Option {
+	return func(b *TiWatch) {
+		b.maxHistoryDepth = n
+	}
 }
 
 type OpType int
@@ -41,22 +198,83 @@ type Op struct {
 	Type OpType
 	Key  string
 	Val  string
+	// Seq numbers the events delivered by a watcher, from 1 on, strictly
+	// increasing, to detect gaps or reordering downstream. It's local to a
+	// watcher, independent of versions, and starts over with a new watcher.
+	Seq uint64
+}
+
+// New returns a TiWatch for namespace in the database of dsn, it connects on
+// Init. Invalid arguments are reported by Init, use NewValidated to fail
+// right away.
+func New(dsn string, namespace string, opts ...Option) *TiWatch {
+	b := &TiWatch{
+		dsn:        dsn,
+		ns:         namespace,
+		watchers:   make(map[*watcher]struct{}),
+		closed:     make(chan struct{}),
+		newBackoff: defaultBackoff,
+
+		poolSize:          50,
+		bulkLoadBatchSize: 1000,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.err = validate(dsn, namespace)
+	return b
+}
+
+// NewValidated is New, but fails right away if dsn isn't a valid DSN or
+// namespace isn't a valid namespace: 1 to 56 ASCII letters, digits or
+// underscores, so that the table name is a valid TiDB identifier.
+func NewValidated(dsn string, namespace string, opts ...Option) (*TiWatch, error) {
+	b := New(dsn, namespace, opts...)
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b, nil
 }
 
-func New(dsn string, namespace string) *TiWatch {
-	return &TiWatch{
-		dsn:      dsn,
-		ns:       namespace,
-		watchers: make(map[string]chan string),
-		versions: make(map[string]int64),
+// maxNamespaceLen keeps table names within the 64 characters of an identifier.
+const maxNamespaceLen = 64 - len("tiwatch_")
+
+func validate(dsn string, namespace string) error {
+	if _, err := mysql.ParseDSN(dsn); err != nil {
+		return fmt.Errorf("tiwatch: invalid DSN: %w", err)
+	}
+	if namespace == "" || len(namespace) > maxNamespaceLen {
+		return fmt.Errorf("tiwatch: invalid namespace %q: must be 1 to %d characters", namespace, maxNamespaceLen)
 	}
+	for _, c := range namespace {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return fmt.Errorf("tiwatch: invalid namespace %q: only letters, digits and '_' are allowed", namespace)
+		}
+	}
+	return nil
 }
 
 func genTableName(ns string) string {
 	return "tiwatch_" + ns
 }
 
+// Init connects to TiDB and creates the table of the namespace, if it doesn't
+// exist yet. Schema options, those whose doc refers here, decide the schema of
+// the table, so they must be the same for every client of a namespace, and
+// can't be changed once the table exists: Init fails with ErrSchemaMismatch
+// if the existing table doesn't match them.
+// Only the first call of Init does anything, later ones return its result.
 func (b *TiWatch) Init() error {
+	b.initOnce.Do(func() {
+		b.initErr = b.init()
+	})
+	return b.initErr
+}
+
+func (b *TiWatch) init() error {
+	if b.err != nil {
+		return b.err
+	}
 	var err error
 	b.db, err = sql.Open("mysql", b.dsn)
 	if err != nil {
@@ -64,10 +282,32 @@ func (b *TiWatch) Init() error {
 	}
 
 	b.db.SetConnMaxLifetime(time.Minute * 3)
-	b.db.SetMaxOpenConns(50)
-	b.db.SetMaxIdleConns(50)
+	b.db.SetMaxOpenConns(b.poolSize)
+	b.db.SetMaxIdleConns(b.poolSize)
+
+	if b.watchPoolSize > 0 {
+		b.watchDB, err = sql.Open("mysql", b.dsn)
+		if err != nil {
+			return err
+		}
+		b.watchDB.SetConnMaxLifetime(time.Minute * 3)
+		b.watchDB.SetMaxOpenConns(b.watchPoolSize)
+		b.watchDB.SetMaxIdleConns(b.watchPoolSize)
+	}
 
-	return b.createTables()
+	if b.expvar {
+		b.publishExpvar()
+	}
+	if err := b.createTables(); err != nil {
+		return err
+	}
+	if err := b.checkSchema(); err != nil {
+		return err
+	}
+	if b.idleTTL > 0 {
+		go b.sweepIdle()
+	}
+	return nil
 }
 
 func (b *TiWatch) DB() *sql.DB {
@@ -75,27 +315,105 @@ func (b *TiWatch) DB() *sql.DB {
 }
 
 func (b *TiWatch) createTables() error {
-	_, err := b.db.Exec(fmt.Sprintf(`
+	// in history mode a key owns one row per version
+	pk := "k"
+	if b.history {
+		pk = "k, version"
+	}
+	valueType := "VARCHAR(255)"
+	if b.jsonValues {
+		valueType = "JSON"
+	}
+	extra := ""
+	if b.idleTTL > 0 {
+		extra = "last_access TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,"
+	}
+	if b.windowedCounters {
+		// start of the window of the count, in Unix milliseconds
+		extra += "window_start BIGINT NULL,"
+	}
+	partitions := ""
+	if b.partitions > 1 {
+		partitions = fmt.Sprintf(" PARTITION BY KEY(k) PARTITIONS %d", b.partitions)
+	}
+	_, err := b.db.Exec(b.tag("init") + fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS %s (
 			k VARCHAR(255) NOT NULL,
-			v VARCHAR(255) NOT NULL,
+			v %s NOT NULL,
 			version BIGINT NOT NULL DEFAULT 0,
-			PRIMARY KEY (k)
-		)
-	`, genTableName(b.ns)))
+			%s
+			PRIMARY KEY (%s)
+		)%s
+	`, genTableName(b.ns), valueType, extra, pk, partitions))
 	if err != nil {
 		return err
 	}
+	if b.idleTTL > 0 {
+		// also for tables created before the index was
+		_, err = b.db.Exec(b.tag("init") + fmt.Sprintf(`
+			CREATE INDEX IF NOT EXISTS idx_last_access ON %s (last_access)
+		`, genTableName(b.ns)))
+		return err
+	}
 	return nil
 }
 
+// Close stops the watchers of b, closing their channels, and closes its
+// connections.
 func (b *TiWatch) Close() error {
+	b.closeOnce.Do(func() { close(b.closed) })
+	if b.watchDB != nil {
+		b.watchDB.Close()
+	}
 	return b.db.Close()
 }
 
+// WithPoolSize sets the number of connections of the main pool, 50 by
+// default. Every transaction, i.e. every write, holds a connection until it
+// commits, so the pool bounds the concurrent writes of b; raise it for highly
+// concurrent writers, lower it to cap the connections b takes on a shared
+// cluster. n <= 0 is ignored rather than lifting the limit.
+func WithPoolSize(n int) Option {
+	return func(b *TiWatch) {
+		if n > 0 {
+			b.poolSize = n
+		}
+	}
+}
+
+// WithWatchPool gives the watchers a dedicated pool of n connections, so
+// their polls don't queue behind writes for connections of the main pool
+// during write bursts. The dedicated connections come on top of the main
+// pool, see WithPoolSize, count them in when sizing connection limits on
+// TiDB.
+func WithWatchPool(n int) Option {
+	return func(b *TiWatch) {
+		b.watchPoolSize = n
+	}
+}
+
+// pollDB returns the connection pool watchers poll with.
+func (b *TiWatch) pollDB() *sql.DB {
+	if b.watchDB != nil {
+		return b.watchDB
+	}
+	return b.db
+}
+
 func (b *TiWatch) Get(key string) (string, bool, error) {
+	defer b.observe("get", key, time.Now())
+	b.touch(key)
+	return b.get(b.db, key)
+}
+
+// querier is what *sql.DB and *sql.Tx have in common for single row reads.
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func (b *TiWatch) get(q querier, key string) (string, bool, error) {
 	var value string
-	err := b.db.QueryRow(fmt.Sprintf(`
+	err := q.QueryRow(b.tag("get")+fmt.Sprintf(`
 		SELECT 
 			v
 		FROM 
@@ -111,17 +429,155 @@ func (b *TiWatch) Get(key string) (string, bool, error) {
 		}
 		return "", false, err
 	}
+	value, err = b.decode(value)
+	if err != nil {
+		return "", false, err
+	}
 	return value, true, nil
 }
 
+// Exists reports whether key exists, without fetching its value.
+func (b *TiWatch) Exists(key string) (bool, error) {
+	defer b.observe("exists", key, time.Now())
+	b.touch(key)
+	var one int
+	err := b.db.QueryRow(b.tag("exists")+fmt.Sprintf(`
+		SELECT
+			1
+		FROM
+			%s
+		WHERE k = ?
+		LIMIT 1
+	`, genTableName(b.ns)), key).Scan(&one)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// MExists reports for each of keys whether it exists, in a single query.
+func (b *TiWatch) MExists(keys []string) (map[string]bool, error) {
+	defer b.observe("exists", "", time.Now())
+	exists := make(map[string]bool, len(keys))
+	if len(keys) == 0 {
+		return exists, nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		b.touch(key)
+		exists[key] = false
+		args[i] = key
+	}
+	rows, err := b.db.Query(b.tag("exists")+fmt.Sprintf(`
+		SELECT DISTINCT
+			k
+		FROM
+			%s
+		WHERE k IN (%s)
+	`, genTableName(b.ns), placeholders(len(keys))), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		exists[key] = true
+	}
+	return exists, rows.Err()
+}
+
+// GetWithVersion is Get, also returning the version of the value, to be
+// passed to SetIfVersion.
+func (b *TiWatch) GetWithVersion(key string) (string, int64, bool, error) {
+	defer b.observe("get", key, time.Now())
+	b.touch(key)
+	return b.getWithVersion(b.db, key)
+}
+
+// getWithVersion reads the latest value of key and its version, from the
+// same row.
+func (b *TiWatch) getWithVersion(q querier, key string) (string, int64, bool, error) {
+	var (
+		value   string
+		version int64
+	)
+	err := q.QueryRow(b.tag("get")+fmt.Sprintf(`
+		SELECT
+			v, version
+		FROM
+			%s
+		WHERE
+			k = ?
+		ORDER BY version DESC
+		LIMIT 1
+	`, genTableName(b.ns)), key).Scan(&value, &version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, false, nil
+		}
+		return "", 0, false, err
+	}
+	value, err = b.decode(value)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return value, version, true, nil
+}
+
+// GetLinearizable is Get, but guaranteed to return the latest value committed
+// before the call started. The read runs in a fresh transaction, whose start
+// timestamp TiDB allocates from PD's TSO when the transaction begins, so its
+// snapshot includes every transaction committed before that. The guarantee
+// only holds if the DSN doesn't enable stale reads (tidb_read_staleness,
+// tidb_snapshot).
+// Use it on paths where acting on an out-of-date value is a correctness bug.
+func (b *TiWatch) GetLinearizable(ctx context.Context, key string) (string, bool, error) {
+	defer b.observe("get", key, time.Now())
+	b.touch(key)
+	txn, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", false, err
+	}
+	defer txn.Rollback()
+
+	var value string
+	err = txn.QueryRowContext(ctx, b.tag("get")+fmt.Sprintf(`
+		SELECT
+			v
+		FROM
+			%s
+		WHERE
+			k = ?
+		ORDER BY version DESC
+		LIMIT 1
+	`, genTableName(b.ns)), key).Scan(&value)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", false, txn.Commit()
+		}
+		return "", false, err
+	}
+	if value, err = b.decode(value); err != nil {
+		return "", false, err
+	}
+	return value, true, txn.Commit()
+}
+
 func (b *TiWatch) Delete(key string) error {
+	defer b.observe("delete", key, time.Now())
 	txn, err := b.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer txn.Rollback()
 
-	_, err = txn.Exec(fmt.Sprintf(`
+	_, err = txn.Exec(b.tag("delete")+fmt.Sprintf(`
 		SELECT 
 			k 
 		FROM
@@ -132,7 +588,7 @@ func (b *TiWatch) Delete(key string) error {
 	if err != nil {
 		return err
 	}
-	_, err = txn.Exec(fmt.Sprintf(`
+	_, err = txn.Exec(b.tag("delete")+fmt.Sprintf(`
 		DELETE FROM
 			%s
 		WHERE k = ?
@@ -143,42 +599,521 @@ func (b *TiWatch) Delete(key string) error {
 	return txn.Commit()
 }
 
+// DeleteIfVersion deletes key only if its current version is still
+// expectedVersion, as returned by GetWithVersion, so a value someone updated
+// in the meantime isn't deleted by mistake. It reports whether key was
+// deleted; a key that doesn't exist isn't.
+func (b *TiWatch) DeleteIfVersion(key string, expectedVersion int64) (bool, error) {
+	defer b.observe("delete", key, time.Now())
+	txn, err := b.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback()
+
+	_, version, exists, err := b.lockLatest(txn, key)
+	if err != nil {
+		return false, err
+	}
+	if !exists || version != expectedVersion {
+		return false, nil
+	}
+	_, err = txn.Exec(b.tag("delete")+fmt.Sprintf(`
+		DELETE FROM
+			%s
+		WHERE k = ?
+	`, genTableName(b.ns)), key)
+	if err != nil {
+		return false, err
+	}
+	return true, txn.Commit()
+}
+
+// latestUnder returns a condition, and its args, restricting a query of rows
+// under prefix to the latest version of each key. It's empty unless in
+// history mode, where a key has a row per version.
+func (b *TiWatch) latestUnder(prefix string) (string, []interface{}) {
+	if !b.history {
+		return "", nil
+	}
+	return fmt.Sprintf(`
+		AND (k, version) IN (
+			SELECT k, MAX(version) FROM %s WHERE k LIKE ? GROUP BY k
+		)
+	`, genTableName(b.ns)), []interface{}{likePrefix(prefix)}
+}
+
+// ClaimOne atomically moves one key under prefix from value fromValue to
+// toValue, and returns it, e.g. to claim a task of a queue by switching it
+// from "pending" to the name of the worker. It reports false if no key under
+// prefix has fromValue. The candidate row is selected FOR UPDATE SKIP LOCKED,
+// so concurrent claimers skip the rows locked by each other instead of
+// queueing on the same one, and a key is claimed by exactly one of them.
+func (b *TiWatch) ClaimOne(prefix string, fromValue string, toValue string) (string, string, bool, error) {
+	defer b.observe("claim", prefix, time.Now())
+	if b.jsonValues && (!json.Valid([]byte(fromValue)) || !json.Valid([]byte(toValue))) {
+		return "", "", false, ErrInvalidJSON
+	}
+	txn, err := b.db.Begin()
+	if err != nil {
+		return "", "", false, err
+	}
+	defer txn.Rollback()
+
+	var (
+		key     string
+		version int64
+	)
+	match := "v = ?"
+	if b.jsonValues {
+		// compare JSON values, not their text
+		match = "v = CAST(? AS JSON)"
+	}
+	latest, latestArgs := b.latestUnder(prefix)
+	err = txn.QueryRow(b.tag("claim")+fmt.Sprintf(`
+		SELECT
+			k, version
+		FROM
+			%s
+		WHERE k LIKE ? AND %s %s
+		ORDER BY k
+		LIMIT 1
+		FOR UPDATE SKIP LOCKED
+	`, genTableName(b.ns), match, latest), append([]interface{}{likePrefix(prefix), b.encode(fromValue)}, latestArgs...)...).Scan(&key, &version)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", false, nil
+		}
+		return "", "", false, err
+	}
+	if err := b.write(txn, key, toValue, version); err != nil {
+		return "", "", false, err
+	}
+	if err := txn.Commit(); err != nil {
+		return "", "", false, err
+	}
+	return key, toValue, true, nil
+}
+
+// DeleteWhere deletes, in one transaction, every key under prefix whose value
+// matches valuePredicate, and returns the number of deleted keys. Watchers of
+// the deleted keys get a TypeDelete as usual.
+// valuePredicate is an SQL condition on the value column v, e.g. "v = ?", with
+// its placeholders bound to args. It sees values as stored, encoded by the
+// Codec of b if there is one. It is pasted into the query as is, so it
+// must never be built from untrusted input; pass such input in args.
+// With WithJSONValues v is a JSON value, compare it with JSON, e.g.
+// "v = CAST(? AS JSON)", a plain string never equals it.
+// In history mode the predicate is evaluated against the latest value only.
+func (b *TiWatch) DeleteWhere(prefix string, valuePredicate string, args ...interface{}) (int64, error) {
+	defer b.observe("delete", prefix, time.Now())
+	txn, err := b.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback()
+
+	latest, latestArgs := b.latestUnder(prefix)
+	queryArgs := append([]interface{}{likePrefix(prefix)}, args...)
+	queryArgs = append(queryArgs, latestArgs...)
+	candidates, err := queryKeys(txn.Query(b.tag("delete")+fmt.Sprintf(`
+		SELECT
+			k
+		FROM
+			%s
+		WHERE k LIKE ? AND (%s) %s
+	`, genTableName(b.ns), valuePredicate, latest), queryArgs...))
+	if err != nil || len(candidates) == 0 {
+		return 0, err
+	}
+	if err := b.lockKeys(txn, candidates); err != nil {
+		return 0, err
+	}
+	// re-check the locked keys against their latest values
+	keyArgs := make([]interface{}, len(candidates))
+	for i, key := range candidates {
+		keyArgs[i] = key
+	}
+	queryArgs = append(keyArgs, args...)
+	queryArgs = append(queryArgs, latestArgs...)
+	keys, err := queryKeys(txn.Query(b.tag("delete")+fmt.Sprintf(`
+		SELECT
+			k
+		FROM
+			%s
+		WHERE k IN (%s) AND (%s) %s
+		FOR UPDATE
+	`, genTableName(b.ns), placeholders(len(candidates)), valuePredicate, latest), queryArgs...))
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	keyArgs = keyArgs[:0]
+	for _, key := range keys {
+		keyArgs = append(keyArgs, key)
+	}
+	_, err = txn.Exec(b.tag("delete")+fmt.Sprintf(`
+		DELETE FROM
+			%s
+		WHERE k IN (%s)
+	`, genTableName(b.ns), placeholders(len(keys))), keyArgs...)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), txn.Commit()
+}
+
+// lockKeys locks keys until txn ends. Every operation locking more than one
+// key must lock them through lockKeys: it locks one key at a time, in sorted
+// order, so that operations with overlapping keys all acquire their locks in
+// the same global order and can't deadlock each other.
+func (b *TiWatch) lockKeys(txn *sql.Tx, keys []string) error {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	for i, key := range sorted {
+		if i > 0 && key == sorted[i-1] {
+			continue
+		}
+		_, err := txn.Exec(b.tag("lock")+fmt.Sprintf(`
+			SELECT
+				k
+			FROM
+				%s
+			WHERE k = ?
+			FOR UPDATE
+		`, genTableName(b.ns)), key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryKeys returns the keys in the first column of rows.
+func queryKeys(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// Set writes value to key, unconditionally overwriting the current value.
+// With WithDefaultWriteMode(WriteModeVersionGuarded) it refuses to write and
+// returns ErrVersionRequired, use SetIfVersion or SetUnguarded instead.
 func (b *TiWatch) Set(key string, value string) error {
+	if b.writeMode == WriteModeVersionGuarded {
+		return ErrVersionRequired
+	}
+	return b.SetUnguarded(key, value)
+}
+
+// SetUnguarded is Set regardless of the write mode: an explicit opt-out of
+// version guarding for writes that are meant to be last-write-wins.
+func (b *TiWatch) SetUnguarded(key string, value string) error {
+	_, err := b.set(key, value, anyVersion)
+	return err
+}
+
+// SetWithoutLock is SetUnguarded without the locking read of the current
+// version: the write is a single upsert statement, which takes the row lock
+// itself and increments the version in place, saving a round trip per write.
+// That's as safe as Set for a plain overwrite, as nothing is decided on the
+// previous value, and meant for write heavy keys with a single writer. In
+// history mode the next version has to be read first, so it's the same as
+// SetUnguarded there.
+func (b *TiWatch) SetWithoutLock(key string, value string) error {
+	if b.history {
+		return b.SetUnguarded(key, value)
+	}
+	defer b.observe("set", key, time.Now())
+	if b.jsonValues && !json.Valid([]byte(value)) {
+		return ErrInvalidJSON
+	}
+	value = b.encode(value)
+	b.checkSize(key, value)
+	return b.upsert(b.db, key, value)
+}
+
+// SetIfVersion writes value to key only if the current version of key is
+// still expectedVersion, as returned by GetWithVersion; a key that doesn't
+// exist has version 0, and an expectedVersion of 0 never overwrites an
+// existing key. It reports whether the value was written.
+//
+// Versions only tell the writes of one incarnation of a key apart: a deleted
+// and recreated key starts over at the first version, so an expectedVersion
+// read before the delete may match the recreated key, and overwrite it. Keep
+// deleted keys deleted, or put a generation in the value, if that matters.
+func (b *TiWatch) SetIfVersion(key string, value string, expectedVersion int64) (bool, error) {
+	return b.set(key, value, expectedVersion)
+}
+
+// maxValueLen is the capacity of the value column, in characters.
+const maxValueLen = 255
+
+// WithValueSizeWarn calls handler for every write of a value that takes more
+// than fraction of the capacity of the value column, e.g. 0.8, as an early
+// warning for keys growing towards the limit. The value is written anyway.
+// size and limit are in characters, of the value as stored. handler runs
+// while the write's transaction is open, so it must return quickly.
+// It has no effect with WithJSONValues, whose column has no such limit.
+func WithValueSizeWarn(fraction float64, handler func(key string, size int, limit int)) Option {
+	return func(b *TiWatch) {
+		b.sizeWarnFraction = fraction
+		b.sizeWarnHandler = handler
+	}
+}
+
+// checkSize calls the size warning handler if the stored value is too large.
+func (b *TiWatch) checkSize(key string, stored string) {
+	if b.sizeWarnHandler == nil || b.jsonValues {
+		return
+	}
+	if size := utf8.RuneCountInString(stored); float64(size) > b.sizeWarnFraction*maxValueLen {
+		b.sizeWarnHandler(key, size, maxValueLen)
+	}
+}
+
+// Append atomically appends suffix to the value of key, separated by sep, and
+// returns the new value. A key that doesn't exist, or is empty, is set to
+// suffix alone. It fails with ErrValueTooLong if the new value wouldn't fit
+// the value column, or, with WithJSONValues, whose column has no such limit,
+// with ErrInvalidJSON if it isn't valid JSON.
+func (b *TiWatch) Append(key string, suffix string, sep string) (string, error) {
+	defer b.observe("append", key, time.Now())
 	txn, err := b.db.Begin()
 	if err != nil {
-		return err
+		return "", err
 	}
 	defer txn.Rollback()
 
-	_, err = txn.Exec(fmt.Sprintf(`
-		SELECT 
-			k 
+	value, version, _, err := b.lockLatest(txn, key)
+	if err != nil {
+		return "", err
+	}
+	if value == "" {
+		value = suffix
+	} else {
+		value = value + sep + suffix
+	}
+	if b.jsonValues {
+		if !json.Valid([]byte(value)) {
+			return "", ErrInvalidJSON
+		}
+	} else if utf8.RuneCountInString(b.encode(value)) > maxValueLen {
+		return "", ErrValueTooLong
+	}
+	if err := b.write(txn, key, value, version); err != nil {
+		return "", err
+	}
+	return value, txn.Commit()
+}
+
+// GetAndSet atomically writes newVal to key and returns the value it replaced,
+// if key existed, e.g. to rotate a secret and revoke the previous one. No
+// write can slip in between the read and the write.
+func (b *TiWatch) GetAndSet(key string, newVal string) (string, bool, error) {
+	defer b.observe("set", key, time.Now())
+	if b.jsonValues && !json.Valid([]byte(newVal)) {
+		return "", false, ErrInvalidJSON
+	}
+	txn, err := b.db.Begin()
+	if err != nil {
+		return "", false, err
+	}
+	defer txn.Rollback()
+
+	old, version, exists, err := b.lockLatest(txn, key)
+	if err != nil {
+		return "", false, err
+	}
+	if err := b.writeOrDedup(txn, key, newVal, version, exists && old == newVal); err != nil {
+		return "", false, err
+	}
+	if err := txn.Commit(); err != nil {
+		return "", false, err
+	}
+	return old, exists, nil
+}
+
+// anyVersion makes set skip the version check.
+const anyVersion int64 = -1
+
+func (b *TiWatch) set(key string, value string, expectedVersion int64) (bool, error) {
+	defer b.observe("set", key, time.Now())
+	if b.jsonValues && !json.Valid([]byte(value)) {
+		return false, ErrInvalidJSON
+	}
+	txn, err := b.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer txn.Rollback()
+
+	latest, version, exists, err := b.lockLatest(txn, key)
+	if err != nil {
+		return false, err
+	}
+	if expectedVersion != anyVersion && version != expectedVersion {
+		return false, nil
+	}
+	if expectedVersion == 0 && exists {
+		// create only, a key left at version 0 by older releases exists
+		return false, nil
+	}
+	if err := b.writeOrDedup(txn, key, value, version, exists && latest == value); err != nil {
+		return false, err
+	}
+	return true, txn.Commit()
+}
+
+// firstVersion is the version of a newly created key, every further write
+// increments it by one, so the version of a key is the number of writes since
+// its creation. A key that doesn't exist has version 0: watchers seeded with
+// the version of a missing key are notified of its creation, and
+// SetIfVersion(key, value, 0) means "create only".
+//
+// Tables written by releases before this convention can hold keys at version
+// 0, which watchers take for missing keys; run RepairAll once after upgrading
+// to move them to firstVersion.
+const firstVersion int64 = 1
+
+// lockLatest locks key until txn ends and returns its latest value and
+// version. The version of a key that doesn't exist is 0.
+func (b *TiWatch) lockLatest(txn *sql.Tx, key string) (string, int64, bool, error) {
+	var (
+		value   string
+		version int64
+	)
+	err := txn.QueryRow(b.tag("lock")+fmt.Sprintf(`
+		SELECT
+			v, version
 		FROM
 			%s
 		WHERE k = ?
+		ORDER BY version DESC
+		LIMIT 1
 		FOR UPDATE
-	`, genTableName(b.ns)), key)
+	`, genTableName(b.ns)), key).Scan(&value, &version)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", 0, false, nil
+		}
+		return "", 0, false, err
+	}
+	value, err = b.decode(value)
+	if err != nil {
+		return "", 0, false, err
+	}
+	return value, version, true, nil
+}
+
+// writeOrDedup is write, but with WithDedupHistory a write of the value that
+// is already stored, unchanged, doesn't append a row.
+func (b *TiWatch) writeOrDedup(txn *sql.Tx, key string, value string, version int64, unchanged bool) error {
+	if !b.history || !b.dedupHistory || !unchanged {
+		return b.write(txn, key, value, version)
+	}
+	if b.dedupKeepVersion {
+		return nil
+	}
+	touch := ""
+	if b.idleTTL > 0 {
+		touch = ", last_access = NOW()"
+	}
+	_, err := txn.Exec(b.tag("set")+fmt.Sprintf(`
+		UPDATE
+			%s
+		SET version = version + 1%s
+		WHERE k = ? AND version = ?
+	`, genTableName(b.ns), touch), key, version)
+	return err
+}
+
+// write stores value as the next version of key within txn, version being
+// what lockLatest returned for key.
+func (b *TiWatch) write(txn *sql.Tx, key string, value string, version int64) error {
+	value = b.encode(value)
+	b.checkSize(key, value)
+	if b.history {
+		// append a row to keep the change history feed
+		version++
+		_, err := txn.Exec(b.tag("set")+fmt.Sprintf(`
+			INSERT INTO
+				%s (k, v, version)
+			VALUES (?, ?, ?)
+		`, genTableName(b.ns)), key, value, version)
+		if err != nil || b.maxHistoryDepth <= 0 {
+			return err
+		}
+		// versions may have gaps, see WithDedupHistory, so trim by rank:
+		// everything up to the newest row past the last ones
+		var cutoff int64
+		err = txn.QueryRow(b.tag("set")+fmt.Sprintf(`
+			SELECT
+				version
+			FROM
+				%s
+			WHERE k = ?
+			ORDER BY version DESC
+			LIMIT 1 OFFSET ?
+		`, genTableName(b.ns)), key, b.maxHistoryDepth).Scan(&cutoff)
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = txn.Exec(b.tag("set")+fmt.Sprintf(`
+			DELETE FROM
+				%s
+			WHERE k = ? AND version <= ?
+		`, genTableName(b.ns)), key, cutoff)
 		return err
 	}
-	// if using INSERT here instead of UPSERT, we can keep change history feed
-	_, err = txn.Exec(fmt.Sprintf(`
+	return b.upsert(txn, key, value)
+}
+
+// execer is what *sql.DB and *sql.Tx have in common for statements.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// upsert stores the encoded value as the next version of key, outside of
+// history mode.
+func (b *TiWatch) upsert(e execer, key string, value string) error {
+	touch := ""
+	if b.idleTTL > 0 {
+		touch = ", last_access = NOW()"
+	}
+	_, err := e.Exec(b.tag("set")+fmt.Sprintf(`
 		INSERT INTO 
 			%s (k, v, version)
 		VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE
 			v = VALUES(v),
-			version = version + 1
-	`, genTableName(b.ns)), key, value, 0)
-
-	if err != nil {
-		return err
-	}
-	return txn.Commit()
+			version = version + 1%s
+	`, genTableName(b.ns), touch), key, value, firstVersion)
+	return err
 }
 
 func (b *TiWatch) getMaxVersion(key string) (int64, error) {
+	return b.getMaxVersionContext(context.Background(), key)
+}
+
+// getMaxVersionContext returns the latest version of key, 0 if it doesn't
+// exist.
+func (b *TiWatch) getMaxVersionContext(ctx context.Context, key string) (int64, error) {
+	defer b.observe("poll", key, time.Now())
 	var version int64
-	err := b.db.QueryRow(fmt.Sprintf(`
+	err := b.pollDB().QueryRowContext(ctx, b.tag("poll")+fmt.Sprintf(`
 		SELECT
 			IFNULL(MAX(version), 0)
 		FROM
@@ -191,55 +1126,387 @@ func (b *TiWatch) getMaxVersion(key string) (int64, error) {
 	return version, nil
 }
 
+// MGetVersions returns the latest version of each of keys in a single query.
+// Keys that don't exist are reported with version 0.
+func (b *TiWatch) MGetVersions(keys []string) (map[string]int64, error) {
+	defer b.observe("versions", "", time.Now())
+	versions := make(map[string]int64, len(keys))
+	if len(keys) == 0 {
+		return versions, nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		versions[key] = 0
+		args[i] = key
+	}
+	rows, err := b.db.Query(b.tag("versions")+fmt.Sprintf(`
+		SELECT
+			k, MAX(version)
+		FROM
+			%s
+		WHERE k IN (%s)
+		GROUP BY k
+	`, genTableName(b.ns), placeholders(len(keys))), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			key     string
+			version int64
+		)
+		if err := rows.Scan(&key, &version); err != nil {
+			return nil, err
+		}
+		versions[key] = version
+	}
+	return versions, rows.Err()
+}
+
+// likePrefix returns a LIKE pattern matching every key starting with prefix.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(prefix) + "%"
+}
+
+// placeholders returns n comma separated '?' for an IN (...) list.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
 func (b *TiWatch) Watch(key string) <-chan Op {
 	ch := make(chan Op)
-	go func() {
-		for {
-			var err error
-			// get local version
-			version, ok := b.versions[key]
-			if !ok {
-				version, err = b.getMaxVersion(key)
-				if err != nil {
-					if err == sql.ErrNoRows {
-						b.Set(key, "")
-					} else {
-						log.Error(err)
-					}
-				}
-				b.versions[key] = version
+	go b.watch(key, unseeded, ch, nil, nil, nil)
+	return ch
+}
+
+// WatchMustExist is Watch for callers that treat a missing key as an error:
+// it returns ErrKeyNotFound if key doesn't exist at the time of the call,
+// instead of watching for it to appear.
+func (b *TiWatch) WatchMustExist(key string) (<-chan Op, error) {
+	_, version, exists, err := b.GetWithVersion(key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	ch := make(chan Op)
+	go b.watch(key, version, ch, nil, nil, nil)
+	return ch, nil
+}
+
+// WatchConsistent reads the current value of key and starts watching it from
+// exactly that read: value and version come from the same row of the same
+// snapshot, and the watcher delivers every change with a later version. So
+// there is no gap between the initial read and the first event, and no
+// change is delivered twice.
+func (b *TiWatch) WatchConsistent(key string) (string, bool, <-chan Op, error) {
+	value, version, exists, err := b.GetWithVersion(key)
+	if err != nil {
+		return "", false, nil, err
+	}
+	ch := make(chan Op)
+	go b.watch(key, version, ch, nil, nil, nil)
+	return value, exists, ch, nil
+}
+
+// WatchMany registers watchers for all of keys at once, seeding their
+// versions with a single MGetVersions query instead of one query per key.
+// Every key gets its own channel. Calling the returned cancel func stops all
+// of the watchers and closes their channels.
+func (b *TiWatch) WatchMany(keys []string) (map[string]<-chan Op, func()) {
+	versions, err := b.MGetVersions(keys)
+	if err != nil {
+		// fall back to seeding every watcher on its own
+		log.Error(err)
+	}
+	stop := make(chan struct{})
+	chs := make(map[string]<-chan Op, len(keys))
+	for _, key := range keys {
+		if _, ok := chs[key]; ok {
+			continue
+		}
+		ch := make(chan Op)
+		chs[key] = ch
+		version, ok := versions[key]
+		if !ok {
+			version = unseeded
+		}
+		go b.watch(key, version, ch, stop, nil, nil)
+	}
+	var once sync.Once
+	return chs, func() {
+		once.Do(func() { close(stop) })
+	}
+}
+
+// TrackedVersions returns the number of key versions currently tracked by the
+// watchers of b. Every watched key costs a single version, held by its watch
+// loop for as long as the watcher runs, so this is also the number of running
+// watchers.
+func (b *TiWatch) TrackedVersions() int {
+	return int(atomic.LoadInt64(&b.tracked))
+}
+
+// unseeded makes a watcher start from the version key has at its first
+// successful poll, retried like any other failing poll.
+const unseeded int64 = -1
+
+// watch polls key and sends every change after version to ch, until stop is
+// closed or b is closed, then it closes ch. A nil stop watches until Close.
+// version may be unseeded. Failing queries are logged, and also
+// sent to errs if it isn't nil and has room for them. With a non-nil acks,
+// every event has to be acked before the watcher moves past it, see
+// WatchWithAck.
+//
+// Failing queries, e.g. while TiDB fails over, don't end the watch: the
+// watcher marks itself as degraded and keeps retrying, waiting as told by a
+// Backoff of its own, see WithBackoff, from the version it had seen last, so
+// no change is skipped once the database is reachable again.
+func (b *TiWatch) watch(key string, version int64, ch chan Op, stop <-chan struct{}, errs chan<- error, acks *acker) {
+	atomic.AddInt64(&b.tracked, 1)
+	defer atomic.AddInt64(&b.tracked, -1)
+	defer close(ch)
+	w := &watcher{key: key, ch: ch, version: version, interval: PollDuration, status: statusPolling}
+	b.watchersMu.Lock()
+	b.watchers[w] = struct{}{}
+	b.watchersMu.Unlock()
+	defer func() {
+		b.watchersMu.Lock()
+		delete(b.watchers, w)
+		b.watchersMu.Unlock()
+	}()
+
+	// sequence number of the last delivered event
+	var seq uint64
+	failures := 0
+	defer func() {
+		if failures > 0 {
+			atomic.AddInt64(&b.degraded, -1)
+		}
+	}()
+	backoff := b.newBackoff()
+	// fail records a failed query and waits before the next attempt, it
+	// returns false if the watcher was stopped meanwhile
+	fail := func(err error) bool {
+		atomic.AddInt64(&b.errs, 1)
+		log.Error(err)
+		select {
+		case errs <- err:
+		default:
+		}
+		failures++
+		if failures == 1 {
+			atomic.AddInt64(&b.degraded, 1)
+		}
+		wait := backoff.Next(failures)
+		w.update(func() {
+			w.lastErr = err
+			w.interval = wait
+			w.status = statusBackingOff
+		})
+		return b.pause(wait, stop)
+	}
+	recovered := func() {
+		if failures > 0 {
+			log.Infof("watch %s: recovered after %d failed attempts", key, failures)
+			atomic.AddInt64(&b.degraded, -1)
+			failures = 0
+			backoff.Reset()
+			w.update(func() {
+				w.interval = PollDuration
+			})
+		}
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-b.closed:
+			return
+		default:
+		}
+		// get remote version
+		atomic.AddInt64(&b.polls, 1)
+		w.update(func() {
+			w.lastPoll = time.Now()
+			w.status = statusPolling
+		})
+		remoteVersion, err := b.getMaxVersion(key)
+		if err != nil {
+			if !fail(err) {
+				return
 			}
-			// get remote version
-			remoteVersion, err := b.getMaxVersion(key)
-			if err != nil {
-				log.Error(err)
+			continue
+		}
+		if version == unseeded {
+			version = remoteVersion
+			w.update(func() {
+				w.version = version
+			})
+		}
+		// if remote version is greater than local version, we need to update local version
+		// someone else must delete the key
+		if remoteVersion == 0 && version > 0 {
+			recovered()
+			w.setStatus(statusDelivering)
+			select {
+			case ch <- Op{Type: TypeDelete, Key: key, Seq: seq + 1}:
+			case <-stop:
+				return
+			case <-b.closed:
+				return
+			}
+			atomic.AddInt64(&b.events, 1)
+			seq++
+			if acks != nil {
+				w.setStatus(statusAwaitingAck)
+			}
+			if acked, ok := acks.wait(seq, stop, b.closed); !ok {
+				return
+			} else if !acked {
+				// redeliver
 				continue
 			}
-			// if remote version is greater than local version, we need to update local version
-			// someone else must delete the key
-			if remoteVersion == 0 && version > 0 {
-				ch <- Op{
-					Type: TypeDelete,
-					Key:  key,
+			version = 0
+			w.update(func() {
+				w.version = version
+			})
+			continue
+		}
+		// if remote version is greater than local version, get value
+		if remoteVersion > version {
+			// value and version of the same row, a write between the poll
+			// and this read is delivered with its own version
+			start := time.Now()
+			value, latest, exists, err := b.getWithVersion(b.pollDB(), key)
+			b.observe("poll", key, start)
+			if err != nil {
+				if !fail(err) {
+					return
 				}
-				b.versions[key] = 0
 				continue
 			}
-			// if remote version is greater than local version, get value
-			if remoteVersion > version {
-				value, _, err := b.Get(key)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
-				ch <- Op{Type: TypeUpdate, Key: key, Val: value}
-				b.versions[key] = remoteVersion
-			} else {
-				// if remote version is less than or equal to local version, sleep
-				time.Sleep(PollDuration)
+			recovered()
+			if !exists {
+				// deleted since the poll, the next poll delivers the delete
+				continue
+			}
+			w.setStatus(statusDelivering)
+			select {
+			case ch <- Op{Type: TypeUpdate, Key: key, Val: value, Seq: seq + 1}:
+			case <-stop:
+				return
+			case <-b.closed:
+				return
+			}
+			atomic.AddInt64(&b.events, 1)
+			seq++
+			if acks != nil {
+				w.setStatus(statusAwaitingAck)
 			}
+			if acked, ok := acks.wait(seq, stop, b.closed); !ok {
+				return
+			} else if !acked {
+				// redeliver the key as it is now
+				continue
+			}
+			version = latest
+			w.update(func() {
+				w.version = version
+			})
+		} else {
+			recovered()
+			// if remote version is less than or equal to local version, sleep
+			w.setStatus(statusSleeping)
+			if !b.pause(PollDuration, stop) {
+				return
+			}
+		}
+	}
+}
+
+// watcher is the state of a running watch loop, for introspection.
+type watcher struct {
+	key string
+	ch  chan Op
+
+	mu sync.Mutex
+	// last delivered version
+	version int64
+	// current wait between polls
+	interval time.Duration
+	lastPoll time.Time
+	// last query error, if any
+	lastErr error
+	// what the watch loop is doing, see WatcherState
+	status string
+}
+
+func (w *watcher) update(fn func()) {
+	w.mu.Lock()
+	fn()
+	w.mu.Unlock()
+}
+
+func (w *watcher) setStatus(status string) {
+	w.update(func() {
+		w.status = status
+	})
+}
 
+// CurrentPollInterval returns the current wait between two polls of the
+// watchers of key: PollDuration for a healthy watcher, the backoff of a
+// failing one. With several watchers of key, it returns the longest wait.
+// It reports false if nobody watches key.
+func (b *TiWatch) CurrentPollInterval(key string) (time.Duration, bool) {
+	b.watchersMu.Lock()
+	defer b.watchersMu.Unlock()
+	var (
+		interval time.Duration
+		found    bool
+	)
+	for w := range b.watchers {
+		if w.key != key {
+			continue
 		}
-	}()
-	return ch
+		w.mu.Lock()
+		if w.interval > interval {
+			interval = w.interval
+		}
+		w.mu.Unlock()
+		found = true
+	}
+	return interval, found
+}
+
+// sleep waits for d, it returns false if stop was closed first.
+func sleep(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// pause is sleep for watchers, it also returns false once b is closed.
+func (b *TiWatch) pause(d time.Duration, stop <-chan struct{}) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-stop:
+		return false
+	case <-b.closed:
+		return false
+	}
+}
+
+// Degraded reports whether any watcher of b is currently failing to reach the
+// database and retrying. Watch channels stay open while degraded.
+func (b *TiWatch) Degraded() bool {
+	return atomic.LoadInt64(&b.degraded) > 0
 }
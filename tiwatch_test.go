@@ -0,0 +1,64 @@
+package tiwatch
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	const dsn = "root@tcp(127.0.0.1:4000)/test"
+	for _, tc := range []struct {
+		name      string
+		dsn       string
+		namespace string
+		wantErr   bool
+	}{
+		{"valid", dsn, "jobs_2", false},
+		{"longest namespace", dsn, strings.Repeat("n", maxNamespaceLen), false},
+		{"invalid DSN", "root@tcp(127.0.0.1:4000", "jobs", true},
+		{"empty namespace", dsn, "", true},
+		{"long namespace", dsn, strings.Repeat("n", maxNamespaceLen+1), true},
+		{"dash", dsn, "my-jobs", true},
+		{"dot", dsn, "test.jobs", true},
+		{"quote", dsn, "jobs`", true},
+		{"non-ASCII", dsn, "jöbs", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := validate(tc.dsn, tc.namespace); (err != nil) != tc.wantErr {
+				t.Fatalf("got %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// Init runs once, later calls return the result of the first one.
+func TestInitOnce(t *testing.T) {
+	b := New("root@tcp(127.0.0.1:4000)/test", "bad-namespace")
+	first := b.Init()
+	if first == nil {
+		t.Fatal("no error for an invalid namespace")
+	}
+	b.err = nil
+	if err := b.Init(); err != first {
+		t.Fatalf("second Init: got %v, want %v", err, first)
+	}
+}
+
+func TestWithPoolSize(t *testing.T) {
+	for _, tc := range []struct {
+		n    int
+		want int
+	}{
+		{10, 10},
+		{0, 50},
+		{-1, 50},
+	} {
+		t.Run(fmt.Sprint(tc.n), func(t *testing.T) {
+			b := New("root@tcp(127.0.0.1:4000)/test", "jobs", WithPoolSize(tc.n))
+			if got := b.poolSize; got != tc.want {
+				t.Fatalf("got %d connections, want %d", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package tiwatch
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestVersionConvention(t *testing.T) {
+	for _, history := range []bool{false, true} {
+		t.Run(fmt.Sprintf("history=%v", history), func(t *testing.T) {
+			var opts []Option
+			if history {
+				opts = append(opts, WithHistory())
+			}
+			b := newTestWatch(t, opts...)
+			version := func(want int64) {
+				t.Helper()
+				_, got, _, err := b.GetWithVersion("k")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Fatalf("version %d, want %d", got, want)
+				}
+			}
+
+			version(0)
+			if ok, err := b.SetIfVersion("k", "a", 0); err != nil || !ok {
+				t.Fatalf("create only on a missing key: %v, %v", ok, err)
+			}
+			version(firstVersion)
+			if err := b.Set("k", "b"); err != nil {
+				t.Fatal(err)
+			}
+			version(firstVersion + 1)
+			if ok, err := b.SetIfVersion("k", "c", 0); err != nil || ok {
+				t.Fatalf("create only on an existing key: %v, %v", ok, err)
+			}
+			version(firstVersion + 1)
+			if err := b.Delete("k"); err != nil {
+				t.Fatal(err)
+			}
+			version(0)
+			if err := b.Set("k", "d"); err != nil {
+				t.Fatal(err)
+			}
+			version(firstVersion)
+		})
+	}
+}
+
+// Keys written at version 0 by older releases exist, so a create only write
+// must not overwrite them, and RepairAll moves them to firstVersion.
+func TestLegacyVersionZero(t *testing.T) {
+	b := newTestWatch(t)
+	_, err := b.DB().Exec("INSERT INTO "+genTableName(b.ns)+" (k, v, version) VALUES (?, ?, 0)", "k", "legacy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.SetIfVersion("k", "new", 0); err != nil || ok {
+		t.Fatalf("create only overwrote a legacy key: %v, %v", ok, err)
+	}
+	changes, err := b.RepairAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VersionChange{{Key: "k", From: 0, To: firstVersion}}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatalf("repaired %v, want %v", changes, want)
+	}
+	value, version, _, err := b.GetWithVersion("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "legacy" || version != firstVersion {
+		t.Fatalf("got %q at %d after repair", value, version)
+	}
+}
+
+// DeleteIfVersion racing writes only ever deletes the value it was given the
+// version of. Every written value ends up exactly once either replaced by a
+// later write, as GetAndSet reports, deleted, or as the final value.
+func TestDeleteIfVersionRacingSet(t *testing.T) {
+	for _, history := range []bool{false, true} {
+		t.Run(fmt.Sprintf("history=%v", history), func(t *testing.T) {
+			var opts []Option
+			if history {
+				opts = append(opts, WithHistory())
+			}
+			b := newTestWatch(t, opts...)
+			const writes = 200
+			var (
+				wg       sync.WaitGroup
+				replaced []string
+				deleted  []string
+				done     = make(chan struct{})
+			)
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				defer close(done)
+				for i := 0; i < writes; i++ {
+					old, existed, err := b.GetAndSet("k", fmt.Sprint(i))
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if existed {
+						replaced = append(replaced, old)
+					}
+				}
+			}()
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					value, version, exists, err := b.GetWithVersion("k")
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if !exists {
+						continue
+					}
+					ok, err := b.DeleteIfVersion("k", version)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					if ok {
+						deleted = append(deleted, value)
+					}
+				}
+			}()
+			wg.Wait()
+
+			seen := make(map[string]string)
+			record := func(value, how string) {
+				if prev, ok := seen[value]; ok {
+					t.Fatalf("%s both %s and %s", value, prev, how)
+				}
+				seen[value] = how
+			}
+			for _, value := range replaced {
+				record(value, "replaced")
+			}
+			for _, value := range deleted {
+				record(value, "deleted")
+			}
+			if value, exists, err := b.Get("k"); err != nil {
+				t.Fatal(err)
+			} else if exists {
+				record(value, "final")
+			}
+			for i := 0; i < writes; i++ {
+				if _, ok := seen[fmt.Sprint(i)]; !ok {
+					t.Fatalf("%d lost", i)
+				}
+			}
+		})
+	}
+}
+
+// RepairAll closes gaps in history versions, unless WithDedupHistory makes
+// them legitimate, and always lifts versions below firstVersion.
+func TestRepairAll(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		opts     []Option
+		versions []int64
+		want     []VersionChange
+	}{
+		{"gap", []Option{WithHistory()}, []int64{1, 2, 5}, []VersionChange{{"k", 2, 4}, {"k", 1, 3}}},
+		{"trimmed", []Option{WithHistory()}, []int64{4, 5, 6}, nil},
+		{"below first", []Option{WithHistory()}, []int64{0, 1}, []VersionChange{{"k", 1, 2}, {"k", 0, 1}}},
+		{"dedup gap", []Option{WithHistory(), WithDedupHistory()}, []int64{1, 2, 5}, nil},
+		{"dedup below first", []Option{WithHistory(), WithDedupHistory()}, []int64{0, 3}, []VersionChange{{"k", 3, 4}, {"k", 0, 1}}},
+		{"dedup keeping versions", []Option{WithHistory(), WithDedupHistoryKeepVersion()}, []int64{1, 3}, []VersionChange{{"k", 1, 2}}},
+		{"latest", nil, []int64{0}, []VersionChange{{"k", 0, 1}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := newTestWatch(t, tc.opts...)
+			for _, version := range tc.versions {
+				_, err := b.DB().Exec("INSERT INTO "+genTableName(b.ns)+" (k, v, version) VALUES (?, ?, ?)", "k", fmt.Sprint(version), version)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			changes, err := b.RepairAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(changes) != fmt.Sprint(tc.want) {
+				t.Fatalf("repaired %v, want %v", changes, tc.want)
+			}
+		})
+	}
+}
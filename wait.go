@@ -0,0 +1,121 @@
+package tiwatch
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/c4pt0r/log"
+)
+
+var (
+	// ErrKeyDeleted is returned by WaitForVersion when the awaited key is
+	// deleted while waiting.
+	ErrKeyDeleted = errors.New("tiwatch: key deleted")
+)
+
+// WaitOption configures a single WaitForVersion call.
+type WaitOption func(*waitFor)
+
+type waitFor struct {
+	throughDeletes bool
+}
+
+// WaitThroughDeletes makes WaitForVersion keep waiting when the key is
+// deleted, for the recreated key to reach the version, instead of failing
+// with ErrKeyDeleted.
+func WaitThroughDeletes() WaitOption {
+	return func(w *waitFor) {
+		w.throughDeletes = true
+	}
+}
+
+// WaitForVersion blocks until the version of key is at least minVersion and
+// returns that version, polling like a watcher does: every PollDuration, and
+// backing off from failing queries as told by WithBackoff. It doesn't care
+// about the value.
+// A delete resets the version of a key, so if key is deleted while waiting,
+// WaitForVersion returns ErrKeyDeleted, unless WaitThroughDeletes is given. It
+// returns ctx.Err() if ctx is done first.
+func (b *TiWatch) WaitForVersion(ctx context.Context, key string, minVersion int64, opts ...WaitOption) (int64, error) {
+	var w waitFor
+	for _, opt := range opts {
+		opt(&w)
+	}
+	backoff := b.newBackoff()
+	failures := 0
+	seen := false
+	for {
+		wait := PollDuration
+		version, err := b.getMaxVersionContext(ctx, key)
+		switch {
+		case ctx.Err() != nil:
+			return 0, ctx.Err()
+		case err != nil:
+			log.Error(err)
+			failures++
+			wait = backoff.Next(failures)
+		case version == 0 && seen && !w.throughDeletes:
+			return 0, ErrKeyDeleted
+		case version >= minVersion && version > 0:
+			return version, nil
+		default:
+			if failures > 0 {
+				failures = 0
+				backoff.Reset()
+			}
+			seen = seen || version > 0
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// WaitForEmpty blocks until no key under prefix is left, e.g. until a cleanup
+// run elsewhere is done, checking every PollDuration with a single row
+// lookup rather than a count and backing off from failing queries like
+// WaitForVersion. It returns as soon as the prefix is empty, or ctx.Err() if
+// ctx is done first.
+func (b *TiWatch) WaitForEmpty(ctx context.Context, prefix string) error {
+	backoff := b.newBackoff()
+	failures := 0
+	for {
+		wait := PollDuration
+		start := time.Now()
+		var one int
+		err := b.pollDB().QueryRowContext(ctx, b.tag("poll")+fmt.Sprintf(`
+			SELECT
+				1
+			FROM
+				%s
+			WHERE k LIKE ?
+			LIMIT 1
+		`, genTableName(b.ns)), likePrefix(prefix)).Scan(&one)
+		b.observe("poll", prefix, start)
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case err == sql.ErrNoRows:
+			return nil
+		case err != nil:
+			log.Error(err)
+			failures++
+			wait = backoff.Next(failures)
+		case failures > 0:
+			failures = 0
+			backoff.Reset()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package tiwatch
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWaitForVersionRetriesFaults(t *testing.T) {
+	b, s := newFakeWatch(t)
+	s.fail(errors.New("injected fault"))
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.WaitForVersion(context.Background(), "k", 2)
+		done <- err
+	}()
+
+	time.Sleep(10 * PollDuration)
+	s.set("k", "a", 2)
+	s.fail(nil)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("got %v, want the version once the fault is gone", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitForVersion didn't return")
+	}
+}
+
+func TestWaitForVersionDeleted(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		opts    []WaitOption
+		version int64
+		err     error
+	}{
+		{"default", nil, 0, ErrKeyDeleted},
+		{"through deletes", []WaitOption{WaitThroughDeletes()}, 3, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, s := newFakeWatch(t)
+			s.set("k", "a", 1)
+			// the first poll sees the key, the second one its delete, and
+			// the third one the recreated key
+			s.afterPoll = func(rows map[string]fakeRow) {
+				delete(rows, "k")
+				s.afterPoll = func(rows map[string]fakeRow) {
+					rows["k"] = fakeRow{v: "b", version: 3}
+				}
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			version, err := b.WaitForVersion(ctx, "k", 3, tc.opts...)
+			if version != tc.version || err != tc.err {
+				t.Fatalf("got %d, %v, want %d, %v", version, err, tc.version, tc.err)
+			}
+		})
+	}
+}
+
+func TestWaitForVersionCanceled(t *testing.T) {
+	b, s := newFakeWatch(t)
+	s.set("k", "a", 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*PollDuration)
+	defer cancel()
+	if _, err := b.WaitForVersion(ctx, "k", 2); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
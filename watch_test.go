@@ -0,0 +1,237 @@
+package tiwatch
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchRecoversFromFaults(t *testing.T) {
+	b, s := newFakeWatch(t)
+	s.set("k", "a", 1)
+	ch := make(chan Op)
+	go b.watch("k", 1, ch, nil, nil, nil)
+
+	s.fail(errors.New("injected fault"))
+	eventually(t, "degraded watcher", b.Degraded)
+	// a change made while the watcher can't reach the database
+	s.set("k", "b", 2)
+	s.fail(nil)
+
+	op := receive(t, ch)
+	if op.Type != TypeUpdate || op.Val != "b" || op.Seq != 1 {
+		t.Fatalf("got %+v, want update to b with seq 1", op)
+	}
+	eventually(t, "recovered watcher", func() bool { return !b.Degraded() })
+	if m := b.Metrics(); m.Errors == 0 {
+		t.Fatalf("no errors counted: %+v", m)
+	}
+}
+
+func TestCloseStopsWatchers(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		fault bool
+	}{
+		{"healthy", false},
+		{"degraded", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, s := newFakeWatch(t)
+			s.set("k", "a", 1)
+			ch := make(chan Op)
+			go b.watch("k", 1, ch, nil, nil, nil)
+			eventually(t, "running watcher", func() bool { return b.TrackedVersions() == 1 })
+			if tc.fault {
+				s.fail(errors.New("injected fault"))
+				eventually(t, "degraded watcher", b.Degraded)
+			}
+
+			b.Close()
+			select {
+			case op, ok := <-ch:
+				if ok {
+					t.Fatalf("got %+v after Close", op)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("channel not closed by Close")
+			}
+			eventually(t, "stopped watcher", func() bool { return b.TrackedVersions() == 0 })
+			if b.Degraded() {
+				t.Fatal("still degraded after Close")
+			}
+		})
+	}
+}
+
+// A write or a delete landing between the version poll of a watcher and its
+// value read must not deliver a value under the wrong version.
+func TestWatchChangeBetweenPollAndRead(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		change func(rows map[string]fakeRow)
+		want   Op
+	}{
+		{
+			name:   "write",
+			change: func(rows map[string]fakeRow) { rows["k"] = fakeRow{v: "c", version: 3} },
+			want:   Op{Type: TypeUpdate, Key: "k", Val: "c", Seq: 1},
+		},
+		{
+			name:   "delete",
+			change: func(rows map[string]fakeRow) { delete(rows, "k") },
+			want:   Op{Type: TypeDelete, Key: "k", Seq: 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b, s := newFakeWatch(t)
+			s.set("k", "a", 1)
+			s.mu.Lock()
+			s.rows["k"] = fakeRow{v: "b", version: 2}
+			s.afterPoll = tc.change
+			s.mu.Unlock()
+			ch := make(chan Op)
+			go b.watch("k", 1, ch, nil, nil, nil)
+
+			if op := receive(t, ch); op != tc.want {
+				t.Fatalf("got %+v, want %+v", op, tc.want)
+			}
+			// the next change is delivered once, nothing is delivered twice
+			s.set("k", "d", 4)
+			if op := receive(t, ch); op.Type != TypeUpdate || op.Val != "d" || op.Seq != 2 {
+				t.Fatalf("got %+v, want update to d with seq 2", op)
+			}
+		})
+	}
+}
+
+// Against concurrent writers, WatchConsistent never delivers the initial
+// value or any value twice, and ends up at the last written one.
+func TestWatchConsistentConcurrentWrites(t *testing.T) {
+	b := newTestWatch(t)
+	if err := b.Set("k", "init"); err != nil {
+		t.Fatal(err)
+	}
+	value, _, ch, err := b.WatchConsistent("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "init" {
+		t.Fatalf("initial value %q", value)
+	}
+
+	const writers, writes = 4, 25
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if err := b.Set("k", fmt.Sprintf("%d-%d", w, i)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	last, _, _, err := b.GetWithVersion("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := map[string]bool{"init": true}
+	for {
+		op := receive(t, ch)
+		if seen[op.Val] {
+			t.Fatalf("%q delivered twice", op.Val)
+		}
+		seen[op.Val] = true
+		if op.Val == last {
+			return
+		}
+	}
+}
+
+// A watcher that can't read the version of key yet keeps retrying, and then
+// starts from that version, not from scratch, so the current value isn't
+// delivered as a change.
+func TestWatchSeedRetried(t *testing.T) {
+	b, s := newFakeWatch(t)
+	s.set("k", "a", 1)
+	s.fail(errors.New("injected fault"))
+	ch := b.Watch("k")
+	eventually(t, "degraded watcher", b.Degraded)
+	s.fail(nil)
+	eventually(t, "recovered watcher", func() bool { return !b.Degraded() })
+
+	select {
+	case op := <-ch:
+		t.Fatalf("got %+v for the version the watch started from", op)
+	case <-time.After(10 * PollDuration):
+	}
+	s.set("k", "b", 2)
+	if op := receive(t, ch); op.Type != TypeUpdate || op.Val != "b" || op.Seq != 1 {
+		t.Fatalf("got %+v, want update to b with seq 1", op)
+	}
+}
+
+// recordingBackoff counts the calls of its watcher.
+type recordingBackoff struct {
+	mu            sync.Mutex
+	nexts, resets int
+}
+
+func (r *recordingBackoff) Next(attempt int) time.Duration {
+	r.mu.Lock()
+	r.nexts++
+	r.mu.Unlock()
+	return 5 * time.Millisecond
+}
+
+func (r *recordingBackoff) Reset() {
+	r.mu.Lock()
+	r.resets++
+	r.mu.Unlock()
+}
+
+// Every watcher backs off with a Backoff of its own.
+func TestWatchBackoffPerWatcher(t *testing.T) {
+	b, s := newFakeWatch(t)
+	var (
+		mu       sync.Mutex
+		backoffs []*recordingBackoff
+	)
+	b.newBackoff = func() Backoff {
+		mu.Lock()
+		defer mu.Unlock()
+		r := &recordingBackoff{}
+		backoffs = append(backoffs, r)
+		return r
+	}
+	s.set("a", "1", 1)
+	s.set("b", "1", 1)
+	s.fail(errors.New("injected fault"))
+	for _, key := range []string{"a", "b"} {
+		go b.watch(key, 1, make(chan Op), nil, nil, nil)
+	}
+	eventually(t, "degraded watchers", func() bool { return atomic.LoadInt64(&b.degraded) == 2 })
+	s.fail(nil)
+	eventually(t, "recovered watchers", func() bool { return !b.Degraded() })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(backoffs) != 2 {
+		t.Fatalf("%d backoffs for 2 watchers", len(backoffs))
+	}
+	for i, r := range backoffs {
+		r.mu.Lock()
+		if r.nexts == 0 || r.resets != 1 {
+			t.Errorf("backoff %d: %d nexts, %d resets", i, r.nexts, r.resets)
+		}
+		r.mu.Unlock()
+	}
+}